@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/go-daemons/internal/apps/helloworld"
+	"github.com/go-daemons/pkg/daemon"
+)
+
+// version is the version of the godaemons binary reported by "godaemons status <app>". It is set at build time with
+// -ldflags "-X main.version=<version>".
+var version = "dev"
+
+func main() {
+	// Entry point for both the "parent" command line processing, and the daemon processing of every app hosted in
+	// this binary. The host routes each invocation to the right app.
+
+	host := daemon.NewHost("godaemons")
+	host.Add(helloworld.NewDaemon(version))
+	host.ProcessCommandLine()
+}
@@ -0,0 +1,301 @@
+package configs
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is the prefix of the environment variables read by LoadEnv.
+const EnvPrefix = "GO_DAEMONS_"
+
+// defaultEnvSeparator separates the items of a list and the entries of a map in an environment variable.
+const defaultEnvSeparator = ","
+
+var (
+	durationType       = reflect.TypeOf(time.Duration(0))
+	configDurationType = reflect.TypeOf(Duration(0))
+	urlType            = reflect.TypeOf(&url.URL{})
+)
+
+// EnvErrors is the error returned by LoadEnv, a message for every environment variable that is invalid, missing or
+// unknown.
+type EnvErrors []string
+
+// Error returns the messages on one line.
+func (errs EnvErrors) Error() string {
+	return fmt.Sprintf("invalid environment, %v", strings.Join(errs, "; "))
+}
+
+// LoadEnv overrides the fields of targets, pointers to structs, with the variables of environ named by the env tags of
+// the fields, e.g. `env:"LOG_DIR"` for GO_DAEMONS_LOG_DIR. The fields of embedded structs are included. Every problem
+// is reported in the EnvErrors returned, rather than falling back to the default: a value that doesn't parse, is out
+// of bounds, a required variable that isn't set, and a GO_DAEMONS_* variable that no target knows, which is most
+// likely misspelt. The valid variables are applied all the same. A variable set to an empty value, e.g.
+// GO_DAEMONS_TIMEOUT=, is the same as one that isn't set: its field keeps its value.
+//
+// The other tags of a field:
+//
+//	required:"true"  the variable must be set, and not empty.
+//	min:"n" max:"n"  the bounds of a number or duration, or of the length of a string, list or map.
+//	sep:";"          the separator of list items and map entries, "," by default. Map entries are key=value.
+//
+// The supported field types are strings, bools, ints, uints, floats, time.Duration, Duration, *url.URL (which must be
+// absolute), and lists and maps with string keys of them.
+//
+// An error that isn't an EnvErrors is returned for a target that isn't a pointer to a struct. An env tag on an
+// unexported field, which can't be set, is reported in the EnvErrors.
+//
+// NOTE: all the targets of a daemon have to be passed in one call, for the unknown variables to be found.
+func LoadEnv(environ []string, targets ...interface{}) error {
+	_, err := loadEnv(environ, targets...)
+	return err
+}
+
+// envLoader holds the state of LoadEnv: the GO_DAEMONS_* variables, the ones the targets know, the problems found,
+// and the JSON keys of the fields set.
+type envLoader struct {
+	values map[string]string
+	known  map[string]bool
+	errs   EnvErrors
+	set    []string
+}
+
+// loadEnv is LoadEnv, returning the JSON keys of the fields set.
+func loadEnv(environ []string, targets ...interface{}) ([]string, error) {
+	loader := &envLoader{values: make(map[string]string), known: make(map[string]bool)}
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 && strings.HasPrefix(parts[0], EnvPrefix) {
+			loader.values[parts[0]] = parts[1]
+		}
+	}
+
+	for _, target := range targets {
+		value := reflect.ValueOf(target)
+		if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+			return nil, fmt.Errorf("cannot load the environment into %T, not a pointer to a struct", target)
+		}
+		loader.loadStruct(value.Elem())
+	}
+
+	names := make([]string, 0, len(loader.values))
+	for name := range loader.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !loader.known[name] {
+			loader.errs = append(loader.errs, fmt.Sprintf("unknown variable %v", name))
+		}
+	}
+	if len(loader.errs) > 0 {
+		return loader.set, loader.errs
+	}
+	return loader.set, nil
+}
+
+// loadStruct sets the tagged fields of the struct value from the variables, and records their variables as known.
+func (loader *envLoader) loadStruct(value reflect.Value) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			loader.loadStruct(value.Field(i))
+			continue
+		}
+		tag := field.Tag.Get("env")
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		name := EnvPrefix + tag
+		loader.known[name] = true
+		if !value.Field(i).CanSet() {
+			loader.errs = append(loader.errs, fmt.Sprintf("%v cannot be set, field %v is unexported", name,
+				field.Name))
+			continue
+		}
+		if loader.values[name] == "" {
+			if field.Tag.Get("required") == "true" {
+				loader.errs = append(loader.errs, fmt.Sprintf("%v is required", name))
+			}
+			continue
+		}
+		raw := loader.values[name]
+
+		sep := field.Tag.Get("sep")
+		if sep == "" {
+			sep = defaultEnvSeparator
+		}
+		parsed := reflect.New(field.Type).Elem()
+		if err := parseEnv(parsed, raw, sep); err != nil {
+			loader.errs = append(loader.errs, fmt.Sprintf("%v=%q is not valid, %v", name, raw, err))
+			continue
+		}
+		if err := checkBounds(parsed, field.Tag); err != nil {
+			loader.errs = append(loader.errs, fmt.Sprintf("%v=%q %v", name, raw, err))
+			continue
+		}
+		value.Field(i).Set(parsed)
+		loader.set = append(loader.set, jsonKey(field))
+	}
+}
+
+// parseEnv sets value, the zero value of its type, from raw.
+func parseEnv(value reflect.Value, raw string, sep string) error {
+	switch value.Type() {
+	case durationType, configDurationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("expected a duration such as 30s or 1m30s")
+		}
+		value.SetInt(int64(d))
+		return nil
+	case urlType:
+		u, err := url.Parse(raw)
+		if err != nil || !u.IsAbs() {
+			return fmt.Errorf("expected an absolute URL")
+		}
+		value.Set(reflect.ValueOf(u))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("expected true or false")
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 0, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected an integer of %v bits", value.Type().Bits())
+		}
+		value.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(raw, 0, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a positive integer of %v bits", value.Type().Bits())
+		}
+		value.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a number")
+		}
+		value.SetFloat(f)
+	case reflect.Slice:
+		list := reflect.MakeSlice(value.Type(), 0, 0)
+		for _, item := range splitEnv(raw, sep) {
+			elem := reflect.New(value.Type().Elem()).Elem()
+			if err := parseEnv(elem, item, sep); err != nil {
+				return fmt.Errorf("item %q, %v", item, err)
+			}
+			list = reflect.Append(list, elem)
+		}
+		value.Set(list)
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map type %v", value.Type())
+		}
+		entries := reflect.MakeMap(value.Type())
+		for _, entry := range splitEnv(raw, sep) {
+			parts := strings.SplitN(entry, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("entry %q, expected key=value", entry)
+			}
+			elem := reflect.New(value.Type().Elem()).Elem()
+			if err := parseEnv(elem, parts[1], sep); err != nil {
+				return fmt.Errorf("entry %q, %v", entry, err)
+			}
+			entries.SetMapIndex(reflect.ValueOf(parts[0]).Convert(value.Type().Key()), elem)
+		}
+		value.Set(entries)
+	default:
+		return fmt.Errorf("unsupported type %v", value.Type())
+	}
+	return nil
+}
+
+// splitEnv splits a list or map variable into its trimmed items. A value of separators and spaces alone, e.g. ",",
+// is an empty list.
+func splitEnv(raw string, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// checkBounds returns an error if value is outside the bounds of the min and max tags.
+func checkBounds(value reflect.Value, tag reflect.StructTag) error {
+	for _, bound := range []string{"min", "max"} {
+		limit, ok := tag.Lookup(bound)
+		if !ok {
+			continue
+		}
+
+		var cmp int
+		var err error
+		switch value.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map:
+			var n int
+			if n, err = strconv.Atoi(limit); err == nil {
+				cmp = compare(float64(value.Len()), float64(n))
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			limitValue := reflect.New(value.Type()).Elem()
+			if err = parseEnv(limitValue, limit, defaultEnvSeparator); err == nil {
+				cmp = compare(toFloat(value), toFloat(limitValue))
+			}
+		default:
+			err = fmt.Errorf("no bounds for %v", value.Type())
+		}
+		if err != nil {
+			return fmt.Errorf("cannot be checked, invalid %v tag %q, %v", bound, limit, err)
+		}
+
+		if bound == "min" && cmp < 0 {
+			return fmt.Errorf("is below the minimum of %v", limit)
+		}
+		if bound == "max" && cmp > 0 {
+			return fmt.Errorf("is above the maximum of %v", limit)
+		}
+	}
+	return nil
+}
+
+// toFloat returns the value of a number or duration as a float64.
+func toFloat(value reflect.Value) float64 {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint())
+	default:
+		return value.Float()
+	}
+}
+
+// compare returns -1, 0 or 1 as a is less than, equal to or greater than b.
+func compare(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,218 @@
+package configs
+
+import (
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envEmbedded is embedded in envConfig, its fields are read as if they were envConfig's.
+type envEmbedded struct {
+	Region string `json:"region" env:"REGION"`
+}
+
+// envConfig is the configuration of the environment tests.
+type envConfig struct {
+	envEmbedded
+	Name     string            `json:"name" env:"NAME" required:"true" max:"8"`
+	Workers  int               `json:"workers" env:"WORKERS" min:"1" max:"16"`
+	Port     uint16            `json:"port" env:"PORT"`
+	Ratio    float64           `json:"ratio" env:"RATIO" min:"0" max:"1"`
+	Debug    bool              `json:"debug" env:"DEBUG"`
+	Timeout  time.Duration     `json:"timeout" env:"TIMEOUT" min:"1s"`
+	Interval Duration          `json:"interval" env:"INTERVAL" max:"1h"`
+	Endpoint *url.URL          `json:"endpoint" env:"ENDPOINT"`
+	Tags     []string          `json:"tags" env:"TAGS" max:"3"`
+	Ports    []int             `json:"ports" env:"PORTS" sep:";"`
+	Labels   map[string]string `json:"labels" env:"LABELS"`
+	Internal string            `json:"internal"`
+	Ignored  string            `json:"ignored" env:"-"`
+}
+
+// TestLoadEnv checks that every supported type is read from its variable, and that the fields whose variables aren't
+// set keep their values.
+func TestLoadEnv(t *testing.T) {
+	endpoint, _ := url.Parse("https://example.com/api")
+	tests := []struct {
+		name    string
+		environ []string
+		want    func(*envConfig)
+		set     []string
+	}{
+		{"required only", []string{"GO_DAEMONS_NAME=app"}, func(c *envConfig) { c.Name = "app" }, []string{"name"}},
+		{"embedded", []string{"GO_DAEMONS_NAME=app", "GO_DAEMONS_REGION=eu"}, func(c *envConfig) {
+			c.Name, c.Region = "app", "eu"
+		}, []string{"name", "region"}},
+		{"numbers", []string{"GO_DAEMONS_NAME=app", "GO_DAEMONS_WORKERS=16", "GO_DAEMONS_PORT=8080",
+			"GO_DAEMONS_RATIO=0.5"}, func(c *envConfig) {
+			c.Name, c.Workers, c.Port, c.Ratio = "app", 16, 8080, 0.5
+		}, []string{"name", "port", "ratio", "workers"}},
+		{"bool", []string{"GO_DAEMONS_NAME=app", "GO_DAEMONS_DEBUG=1"}, func(c *envConfig) {
+			c.Name, c.Debug = "app", true
+		}, []string{"debug", "name"}},
+		{"durations", []string{"GO_DAEMONS_NAME=app", "GO_DAEMONS_TIMEOUT=1m30s", "GO_DAEMONS_INTERVAL=1h"},
+			func(c *envConfig) {
+				c.Name, c.Timeout, c.Interval = "app", 90*time.Second, Duration(time.Hour)
+			}, []string{"interval", "name", "timeout"}},
+		{"url", []string{"GO_DAEMONS_NAME=app", "GO_DAEMONS_ENDPOINT=https://example.com/api"}, func(c *envConfig) {
+			c.Name, c.Endpoint = "app", endpoint
+		}, []string{"endpoint", "name"}},
+		{"lists", []string{"GO_DAEMONS_NAME=app", "GO_DAEMONS_TAGS= a, b ,,c", "GO_DAEMONS_PORTS=80;443"},
+			func(c *envConfig) {
+				c.Name, c.Tags, c.Ports = "app", []string{"a", "b", "c"}, []int{80, 443}
+			}, []string{"name", "ports", "tags"}},
+		{"empty list", []string{"GO_DAEMONS_NAME=app", "GO_DAEMONS_TAGS= , "}, func(c *envConfig) {
+			c.Name, c.Tags = "app", []string{}
+		}, []string{"name", "tags"}},
+		// An empty variable is the same as one that isn't set, rather than a value that doesn't parse.
+		{"empty values", []string{"GO_DAEMONS_NAME=app", "GO_DAEMONS_WORKERS=", "GO_DAEMONS_TIMEOUT=",
+			"GO_DAEMONS_ENDPOINT=", "GO_DAEMONS_TAGS=", "GO_DAEMONS_REGION="}, func(c *envConfig) { c.Name = "app" },
+			[]string{"name"}},
+		{"map", []string{"GO_DAEMONS_NAME=app", "GO_DAEMONS_LABELS=team=core, tier=a=b"}, func(c *envConfig) {
+			c.Name, c.Labels = "app", map[string]string{"team": "core", "tier": "a=b"}
+		}, []string{"labels", "name"}},
+		{"other variables", []string{"GO_DAEMONS_NAME=app", "HOME=/root", "GO_DAEMONS", "PATH=/bin"},
+			func(c *envConfig) { c.Name = "app" }, []string{"name"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got := envConfig{Workers: 4, Internal: "kept", Ignored: "kept"}
+			set, err := loadEnv(test.environ, &got)
+			if err != nil {
+				t.Fatalf("loadEnv(%q): %v", test.environ, err)
+			}
+			want := envConfig{Workers: 4, Internal: "kept", Ignored: "kept"}
+			test.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("loadEnv(%q) = %+v, want %+v", test.environ, got, want)
+			}
+			sort.Strings(set)
+			if !reflect.DeepEqual(set, test.set) {
+				t.Errorf("loadEnv(%q) set %v, want %v", test.environ, set, test.set)
+			}
+		})
+	}
+}
+
+// TestLoadEnvErrors checks that every invalid, out of range, missing or unknown variable is reported, in one
+// EnvErrors, and that the valid variables are applied all the same.
+func TestLoadEnvErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		errs    []string
+	}{
+		{"missing required", nil, []string{"GO_DAEMONS_NAME is required"}},
+		{"empty required", []string{"GO_DAEMONS_NAME="}, []string{"GO_DAEMONS_NAME is required"}},
+		{"unknown", []string{"GO_DAEMONS_NAME=app", "GO_DAEMONS_WORKER=2", "GO_DAEMONS_INTERNAL=x",
+			"GO_DAEMONS_IGNORED=x"}, []string{
+			"unknown variable GO_DAEMONS_IGNORED",
+			"unknown variable GO_DAEMONS_INTERNAL",
+			"unknown variable GO_DAEMONS_WORKER",
+		}},
+		{"bad values", []string{"GO_DAEMONS_NAME=app", "GO_DAEMONS_WORKERS=four", "GO_DAEMONS_PORT=-1",
+			"GO_DAEMONS_RATIO=half", "GO_DAEMONS_DEBUG=yes", "GO_DAEMONS_TIMEOUT=30", "GO_DAEMONS_ENDPOINT=/api",
+			"GO_DAEMONS_PORTS=80;http", "GO_DAEMONS_LABELS=team"}, []string{
+			`GO_DAEMONS_WORKERS="four" is not valid, expected an integer of 64 bits`,
+			`GO_DAEMONS_PORT="-1" is not valid, expected a positive integer of 16 bits`,
+			`GO_DAEMONS_RATIO="half" is not valid, expected a number`,
+			`GO_DAEMONS_DEBUG="yes" is not valid, expected true or false`,
+			`GO_DAEMONS_TIMEOUT="30" is not valid, expected a duration such as 30s or 1m30s`,
+			`GO_DAEMONS_ENDPOINT="/api" is not valid, expected an absolute URL`,
+			`GO_DAEMONS_PORTS="80;http" is not valid, item "http", expected an integer of 64 bits`,
+			`GO_DAEMONS_LABELS="team" is not valid, entry "team", expected key=value`,
+		}},
+		{"overflow", []string{"GO_DAEMONS_NAME=app", "GO_DAEMONS_PORT=65536"}, []string{
+			`GO_DAEMONS_PORT="65536" is not valid, expected a positive integer of 16 bits`,
+		}},
+		{"out of range", []string{"GO_DAEMONS_NAME=much too long", "GO_DAEMONS_WORKERS=0", "GO_DAEMONS_RATIO=1.5",
+			"GO_DAEMONS_TIMEOUT=500ms", "GO_DAEMONS_INTERVAL=2h", "GO_DAEMONS_TAGS=a,b,c,d"}, []string{
+			`GO_DAEMONS_NAME="much too long" is above the maximum of 8`,
+			`GO_DAEMONS_WORKERS="0" is below the minimum of 1`,
+			`GO_DAEMONS_RATIO="1.5" is above the maximum of 1`,
+			`GO_DAEMONS_TIMEOUT="500ms" is below the minimum of 1s`,
+			`GO_DAEMONS_INTERVAL="2h" is above the maximum of 1h`,
+			`GO_DAEMONS_TAGS="a,b,c,d" is above the maximum of 3`,
+		}},
+		{"all together", []string{"GO_DAEMONS_WORKERS=17", "GO_DAEMONS_DEBUG=maybe", "GO_DAEMONS_NMAE=app"},
+			[]string{
+				"GO_DAEMONS_NAME is required",
+				`GO_DAEMONS_WORKERS="17" is above the maximum of 16`,
+				`GO_DAEMONS_DEBUG="maybe" is not valid, expected true or false`,
+				"unknown variable GO_DAEMONS_NMAE",
+			}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			config := envConfig{Workers: 4}
+			err := LoadEnv(append(test.environ, "GO_DAEMONS_REGION=eu"), &config)
+			errs, ok := err.(EnvErrors)
+			if !ok {
+				t.Fatalf("LoadEnv(%q) = %v, want EnvErrors", test.environ, err)
+			}
+			if !reflect.DeepEqual([]string(errs), test.errs) {
+				t.Errorf("LoadEnv(%q) errors:\n%v\nwant:\n%v", test.environ, strings.Join(errs, "\n"),
+					strings.Join(test.errs, "\n"))
+			}
+			if !strings.HasPrefix(err.Error(), "invalid environment, ") {
+				t.Errorf("LoadEnv(%q) = %q, want every problem on one line", test.environ, err)
+			}
+			// The invalid variables leave their fields alone, the valid ones are applied.
+			if config.Workers != 4 || config.Region != "eu" {
+				t.Errorf("LoadEnv(%q) left workers %v and region %q, want 4 and eu", test.environ, config.Workers,
+					config.Region)
+			}
+		})
+	}
+}
+
+// TestLoadEnvTargets checks that the variables of every target are known, so none of them is reported as unknown,
+// and that a target LoadEnv can't set is refused.
+func TestLoadEnvTargets(t *testing.T) {
+	var config envConfig
+	var other struct {
+		Level string `json:"level" env:"LEVEL"`
+	}
+	err := LoadEnv([]string{"GO_DAEMONS_NAME=app", "GO_DAEMONS_LEVEL=debug"}, &config, &other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Name != "app" || other.Level != "debug" {
+		t.Errorf("LoadEnv set %q and %q, want app and debug", config.Name, other.Level)
+	}
+
+	err = LoadEnv([]string{"GO_DAEMONS_NAME=app", "GO_DAEMONS_LEVEL=debug"}, &config)
+	if want := (EnvErrors{"unknown variable GO_DAEMONS_LEVEL"}); !reflect.DeepEqual(err, want) {
+		t.Errorf("LoadEnv without the second target = %v, want %v", err, want)
+	}
+
+	var nilConfig *envConfig
+	var number int
+	for _, target := range []interface{}{config, nilConfig, &number, nil} {
+		err = LoadEnv([]string{"GO_DAEMONS_NAME=app"}, target)
+		if _, ok := err.(EnvErrors); err == nil || ok {
+			t.Errorf("LoadEnv into %T = %v, want an error about the target", target, err)
+		}
+	}
+
+	var unexported struct {
+		Name  string `json:"name" env:"NAME"`
+		level string `env:"LEVEL"`
+	}
+	want := EnvErrors{"GO_DAEMONS_LEVEL cannot be set, field level is unexported"}
+	for _, environ := range [][]string{{"GO_DAEMONS_NAME=app"}, {"GO_DAEMONS_NAME=app", "GO_DAEMONS_LEVEL=debug"}} {
+		if err = LoadEnv(environ, &unexported); !reflect.DeepEqual(err, want) {
+			t.Errorf("LoadEnv(%q) with an unexported field = %v, want %v", environ, err, want)
+		}
+	}
+	if unexported.Name != "app" || unexported.level != "" {
+		t.Errorf("LoadEnv set %q and %q, want app and nothing", unexported.Name, unexported.level)
+	}
+}
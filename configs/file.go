@@ -0,0 +1,120 @@
+package configs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// FileExtensions are the configuration file formats read by ReadFile, in the order FindFile looks for them.
+var FileExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+// FindFile returns the configuration file of the daemon name in dir, dir/name with one of FileExtensions, or "" if
+// there is none.
+func FindFile(dir string, name string) string {
+	for _, ext := range FileExtensions {
+		fileName := filepath.Join(dir, name+ext)
+		if _, err := os.Stat(fileName); err == nil {
+			return fileName
+		}
+	}
+	return ""
+}
+
+// ReadFile reads the configuration file name into config, a pointer to a struct with json tags, overwriting the
+// fields the file sets. The format is chosen by the extension: ".json", ".yaml" or ".yml", or ".toml". Keys the struct
+// doesn't have are an error, so a misspelt key isn't silently ignored.
+//
+// NOTE: YAML and TOML are decoded into generic values first, then into config by way of JSON, so a TOML date ends up
+// as an RFC 3339 string, and the fields need no yaml or toml tags.
+func ReadFile(name string, config interface{}) error {
+	_, err := readFile(name, config)
+	return err
+}
+
+// readFile is ReadFile, returning the top level keys the file sets.
+func readFile(name string, config interface{}) ([]string, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]interface{}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+	case ".yaml", ".yml":
+		values, err = parseYAML(data)
+	case ".toml":
+		values = make(map[string]interface{})
+		_, err = toml.Decode(string(data), &values)
+	default:
+		return nil, fmt.Errorf("%v has an unknown configuration file format, use one of %v", name,
+			strings.Join(FileExtensions, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %v, %v", name, err)
+	}
+	if values != nil {
+		// YAML and TOML are decoded by way of JSON, so the json tags and JSON decoding of the fields apply to every
+		// format.
+		if data, err = json.Marshal(values); err != nil {
+			return nil, fmt.Errorf("cannot read %v, %v", name, err)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("cannot read %v, %v", name, err)
+	}
+
+	var keys map[string]json.RawMessage
+	_ = json.Unmarshal(data, &keys)
+	set := make([]string, 0, len(keys))
+	for key := range keys {
+		set = append(set, key)
+	}
+	return set, nil
+}
+
+// parseYAML returns the mapping of a YAML document. An empty document is an empty mapping, and a key set twice is an
+// error.
+func parseYAML(data []byte) (map[string]interface{}, error) {
+	var document interface{}
+	if err := yaml.UnmarshalStrict(data, &document); err != nil {
+		return nil, err
+	}
+	if document == nil {
+		return make(map[string]interface{}), nil
+	}
+	mapping, ok := jsonValue(document).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the document is not a mapping")
+	}
+	return mapping, nil
+}
+
+// jsonValue returns a value decoded from YAML with its mappings, which have interface{} keys, turned into
+// map[string]interface{}, so it can be marshalled into JSON.
+func jsonValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		mapping := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			mapping[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return mapping
+	case []interface{}:
+		for i, item := range value {
+			value[i] = jsonValue(item)
+		}
+	}
+	return value
+}
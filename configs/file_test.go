@@ -0,0 +1,188 @@
+package configs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fileConfig is the configuration of the configuration file tests.
+type fileConfig struct {
+	Name    string            `json:"name"`
+	Workers int               `json:"workers"`
+	Ratio   float64           `json:"ratio"`
+	Debug   bool              `json:"debug"`
+	Timeout Duration          `json:"timeout"`
+	Tags    []string          `json:"tags"`
+	Labels  map[string]string `json:"labels"`
+	Server  struct {
+		Host  string `json:"host"`
+		Ports []int  `json:"ports"`
+	} `json:"server"`
+}
+
+// TestReadFile checks the syntax of each configuration file format that reaches the fields of the configuration.
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want func(*fileConfig)
+	}{
+		{"json", `{"name": "app", "workers": 4, "tags": ["a", "b"], "server": {"host": "localhost"}}`,
+			func(c *fileConfig) {
+				c.Name, c.Workers, c.Tags, c.Server.Host = "app", 4, []string{"a", "b"}, "localhost"
+			}},
+
+		{"yaml", "# comment\nname: app # comment\nworkers: 4\nratio: 0.5\ndebug: true\ntimeout: 30s\n",
+			func(c *fileConfig) {
+				c.Name, c.Workers, c.Ratio, c.Debug, c.Timeout = "app", 4, 0.5, true, Duration(30*time.Second)
+			}},
+		// YAML 1.1 reads a leading 0 as octal, TOML as decimal.
+		{"yaml", "workers: 010\n", func(c *fileConfig) { c.Workers = 8 }},
+		{"yaml", "workers: 10\n", func(c *fileConfig) { c.Workers = 10 }},
+		{"yaml", "name: \"a # b\"\n", func(c *fileConfig) { c.Name = "a # b" }},
+		{"yaml", "name: 'it''s'\n", func(c *fileConfig) { c.Name = "it's" }},
+		{"yaml", "name: it's\n", func(c *fileConfig) { c.Name = "it's" }},
+		{"yaml", "name: |\n  one\n  two\n", func(c *fileConfig) { c.Name = "one\ntwo\n" }},
+		{"yaml", "tags: [a, 'b, c']\n", func(c *fileConfig) { c.Tags = []string{"a", "b, c"} }},
+		{"yaml", "tags:\n- a\n- b\n", func(c *fileConfig) { c.Tags = []string{"a", "b"} }},
+		{"yaml", "tags:\n  - a\n  - b\n", func(c *fileConfig) { c.Tags = []string{"a", "b"} }},
+		{"yaml", "labels:\n  a.b: c\n  \"x: y\": z\n", func(c *fileConfig) {
+			c.Labels = map[string]string{"a.b": "c", "x: y": "z"}
+		}},
+		{"yaml", "labels: {a: b}\n", func(c *fileConfig) { c.Labels = map[string]string{"a": "b"} }},
+		{"yaml", "server:\n  host: localhost\n  ports: [80, 443]\n", func(c *fileConfig) {
+			c.Server.Host, c.Server.Ports = "localhost", []int{80, 443}
+		}},
+		{"yaml", "base: &base localhost\nserver:\n  host: *base\n", nil},
+		{"yaml", "---\nname: app\n", func(c *fileConfig) { c.Name = "app" }},
+		{"yaml", "", func(c *fileConfig) {}},
+
+		{"toml", "# comment\nname = \"app\" # comment\nworkers = 4\nratio = 0.5\ndebug = true\ntimeout = \"30s\"\n",
+			func(c *fileConfig) {
+				c.Name, c.Workers, c.Ratio, c.Debug, c.Timeout = "app", 4, 0.5, true, Duration(30*time.Second)
+			}},
+		{"toml", "workers = 1_000\n", func(c *fileConfig) { c.Workers = 1000 }},
+		{"toml", "workers = 010\n", func(c *fileConfig) { c.Workers = 10 }},
+		{"toml", "name = 'C:\\app'\n", func(c *fileConfig) { c.Name = `C:\app` }},
+		{"toml", "name = \"a # b\"\n", func(c *fileConfig) { c.Name = "a # b" }},
+		{"toml", "name = \"\"\"\none\ntwo\"\"\"\n", func(c *fileConfig) { c.Name = "one\ntwo" }},
+		{"toml", "tags = [\"a\", \"b\",]\n", func(c *fileConfig) { c.Tags = []string{"a", "b"} }},
+		{"toml", "[labels]\n\"a.b\" = \"c\"\n\"x = y\" = \"z\"\n", func(c *fileConfig) {
+			c.Labels = map[string]string{"a.b": "c", "x = y": "z"}
+		}},
+		{"toml", "labels = { a = \"b\" }\n", func(c *fileConfig) { c.Labels = map[string]string{"a": "b"} }},
+		{"toml", "[server]\nhost = \"localhost\"\nports = [80, 443]\n", func(c *fileConfig) {
+			c.Server.Host, c.Server.Ports = "localhost", []int{80, 443}
+		}},
+		{"toml", "", func(c *fileConfig) {}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			name := writeConfigFile(t, "test."+test.name, test.file)
+			var got fileConfig
+			err := ReadFile(name, &got)
+			if test.want == nil {
+				// YAML aliases are expanded, so the anchor is an unknown key.
+				if err == nil {
+					t.Errorf("ReadFile(%q) accepted an unknown key", test.file)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadFile(%q): %v", test.file, err)
+			}
+			var want fileConfig
+			test.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ReadFile(%q) = %+v, want %+v", test.file, got, want)
+			}
+		})
+	}
+}
+
+// TestReadFileErrors checks that invalid files, unknown keys and values of the wrong type are errors in every format.
+func TestReadFileErrors(t *testing.T) {
+	tests := []struct {
+		ext  string
+		file string
+	}{
+		{"json", `{"nmae": "app"}`},
+		{"json", `{"workers": "four"}`},
+		{"json", `{"name": }`},
+		{"yaml", "nmae: app\n"},
+		{"yaml", "workers: four\n"},
+		{"yaml", "server:\n  hots: localhost\n"},
+		{"yaml", "name: app\nname: other\n"},
+		{"yaml", "name: [app\n"},
+		{"yaml", "- app\n"},
+		{"toml", "nmae = \"app\"\n"},
+		{"toml", "name = app\n"},
+		{"toml", "name = \"app\"\nname = \"other\"\n"},
+		{"toml", "[server]\nhots = \"localhost\"\n"},
+		{"toml", "workers = 4.5\n"},
+		{"ini", "name = app\n"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.ext, func(t *testing.T) {
+			name := writeConfigFile(t, "test."+test.ext, test.file)
+			var config fileConfig
+			if err := ReadFile(name, &config); err == nil {
+				t.Errorf("ReadFile(%q) succeeded with %+v", test.file, config)
+			}
+		})
+	}
+}
+
+// TestReadFileKeys checks that readFile returns the top level keys the file sets, whatever its format.
+func TestReadFileKeys(t *testing.T) {
+	files := map[string]string{
+		"json": `{"name": "app", "server": {"host": "localhost"}}`,
+		"yaml": "name: app\nserver:\n  host: localhost\n",
+		"toml": "name = \"app\"\n[server]\nhost = \"localhost\"\n",
+	}
+	for ext, file := range files {
+		var config fileConfig
+		keys, err := readFile(writeConfigFile(t, "test."+ext, file), &config)
+		if err != nil {
+			t.Fatalf("%v: %v", ext, err)
+		}
+		sort.Strings(keys)
+		if want := []string{"name", "server"}; !reflect.DeepEqual(keys, want) {
+			t.Errorf("%v: keys %v, want %v", ext, keys, want)
+		}
+	}
+}
+
+// TestFindFile checks that FindFile looks for the formats in the order of FileExtensions.
+func TestFindFile(t *testing.T) {
+	dir := t.TempDir()
+	if name := FindFile(dir, "app"); name != "" {
+		t.Errorf("FindFile in an empty directory = %v", name)
+	}
+	for _, ext := range []string{".toml", ".yaml", ".json"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "app"+ext), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if name, want := FindFile(dir, "app"), filepath.Join(dir, "app"+ext); name != want {
+			t.Errorf("FindFile = %v, want %v", name, want)
+		}
+	}
+}
+
+// writeConfigFile writes a configuration file in a temporary directory, and returns its name.
+func writeConfigFile(t *testing.T, base string, content string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), base)
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
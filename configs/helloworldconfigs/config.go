@@ -0,0 +1,127 @@
+package helloworldconfigs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-daemons/configs"
+)
+
+// Config is the reloadable configuration of the HelloWorld daemon. The package-level vars, and
+// configs.DefaultLogPath() and configs.DefaultPidPath(), are its defaults, and LoadConfig layers the configuration
+// file, the GO_DAEMONS_* environment variables and the --set flags over them.
+//
+// NOTE: the directories are only read when the daemon starts, a reload doesn't move the PID file, the log file or the
+// working directory of a running daemon.
+type Config struct {
+	// Common are the settings shared by every daemon, see configs.SetCommon.
+	configs.Common
+
+	// Message is the message logged by every orchestration run.
+	Message string `json:"message" env:"MESSAGE"`
+
+	// LogDir is the directory of the log file, see configs.DefaultLogPath.
+	LogDir string `json:"log_dir" env:"LOG_DIR"`
+
+	// PidDir is the directory of the PID file, see configs.DefaultPidPath.
+	PidDir string `json:"pid_dir" env:"PID_DIR"`
+
+	// WorkingDir, see the package-level var.
+	WorkingDir string `json:"working_dir" env:"WORKING_DIR"`
+
+	// CreateCheckTime, see the package-level var.
+	CreateCheckTime configs.Duration `json:"create_check_time" env:"CREATE_CHECK_TIME" min:"1s"`
+
+	// OrchestrationWaitTime, see the package-level var.
+	OrchestrationWaitTime configs.Duration `json:"orchestration_wait_time" env:"ORCHESTRATION_WAIT_TIME" min:"1s"`
+}
+
+// DefaultConfig returns the configuration used when there is no configuration file.
+func DefaultConfig() *Config {
+	return &Config{
+		Common:                configs.DefaultCommon(),
+		Message:               "****Hello world******",
+		LogDir:                configs.DefaultLogPath(),
+		PidDir:                configs.DefaultPidPath(),
+		WorkingDir:            WorkingDir,
+		CreateCheckTime:       configs.Duration(CreateCheckTime),
+		OrchestrationWaitTime: configs.Duration(OrchestrationWaitTime),
+	}
+}
+
+// ConfigFiles returns the configuration files to read: name, the value of --config, if given, otherwise the
+// helloworld.json, .yaml, .yml or .toml file in configs.ConfigPath, if any. The profiles are found next to name, e.g.
+// helloworld.prod.yaml, or in configs.ConfigPath.
+func ConfigFiles(name string) configs.Files {
+	if name != "" {
+		return configs.Files{Name: name, Profiles: strings.TrimSuffix(name, filepath.Ext(name))}
+	}
+	profiles := filepath.Join(configs.ConfigPath, AppName)
+	return configs.Files{Name: configs.FindFile(configs.ConfigPath, AppName), Profiles: profiles}
+}
+
+// LoadConfig returns the configuration built from, in increasing order of precedence, the defaults, the configuration
+// file, the profile of the environment, the GO_DAEMONS_* variables of environ, and settings, the "key=value" values of
+// --set, along with the source of every key; see configs.Load. The result is validated, and every invalid or unknown
+// GO_DAEMONS_* variable is reported in one error.
+func LoadConfig(files configs.Files, environ []string, settings []string) (*Config, configs.Sources, error) {
+	config := DefaultConfig()
+	sources, err := configs.Load(config, files, environ, settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = config.Validate(); err != nil {
+		if files.Name != "" {
+			return nil, nil, fmt.Errorf("%v, %v", files.Name, err)
+		}
+		return nil, nil, err
+	}
+	return config, sources, nil
+}
+
+// LogFile returns the absolute pathname of the log file.
+func (config *Config) LogFile() string {
+	return filepath.Join(config.LogDir, LogName)
+}
+
+// PidFile returns the absolute pathname of the PID file.
+func (config *Config) PidFile() string {
+	return filepath.Join(config.PidDir, filepath.Base(PidFile))
+}
+
+// SystemPidFile returns the PID file the daemon has when root starts it, in configs.PidPath, when the configuration
+// leaves the PID file directory at the default of another user; "" otherwise. See daemon.Paths.
+func (config *Config) SystemPidFile() string {
+	if config.PidDir != configs.DefaultPidPath() || config.PidDir == configs.PidPath {
+		return ""
+	}
+	return filepath.Join(configs.PidPath, filepath.Base(PidFile))
+}
+
+// Validate returns an error describing the first invalid setting in the configuration.
+func (config *Config) Validate() error {
+	if err := config.Common.Validate(); err != nil {
+		return err
+	}
+	if config.Message == "" {
+		return fmt.Errorf("message must not be empty")
+	}
+	for _, dir := range []struct {
+		key  string
+		path string
+	}{{"log_dir", config.LogDir}, {"pid_dir", config.PidDir}, {"working_dir", config.WorkingDir}} {
+		if !filepath.IsAbs(dir.path) {
+			return fmt.Errorf("%v must be an absolute path, not %q", dir.key, dir.path)
+		}
+	}
+	if time.Duration(config.CreateCheckTime) <= 0 {
+		return fmt.Errorf("create_check_time must be positive, not %v", time.Duration(config.CreateCheckTime))
+	}
+	if time.Duration(config.OrchestrationWaitTime) < time.Second {
+		return fmt.Errorf("orchestration_wait_time must be at least 1s, not %v",
+			time.Duration(config.OrchestrationWaitTime))
+	}
+	return nil
+}
@@ -20,17 +20,21 @@ const AppName = "helloworld"
 // LogName is the filename of the daemon log file that is written to when the HelloWorld daemon is running.
 var LogName = fmt.Sprintf("%s.log", AppName)
 
-// PidFile is the absolute pathname/filename of the PID file that is used when the HelloWorld daemon is running.
-var PidFile = filepath.Join(configs.PidPath, fmt.Sprintf("%s.pid", AppName))
+// PidFile is the default absolute pathname/filename of the PID file that is used when the HelloWorld daemon is
+// running. The configuration file can move it to another directory, see Config.PidFile.
+var PidFile = filepath.Join(configs.DefaultPidPath(), fmt.Sprintf("%s.pid", AppName))
 
-// WorkingDir is the location where the HelloWorld daemon will be running from. In this case were simply using the
-// current directory where the daemon was run from as the working directory.
-var WorkingDir = filepath.Join("/Users/abrd/go/src/github.com/go-daemons", fmt.Sprintf("/cmd/%s", AppName))
+// WorkingDir is the default location where the HelloWorld daemon will be running from, "working_dir" in the
+// configuration file. The root directory doesn't keep a file system busy, and doesn't depend on the directory the
+// daemon was started from.
+var WorkingDir = "/"
 
 // *****************************
 // *** Timers ***
 // *****************************
 
+// NOTE: the timers are the defaults of the configuration file, see Config.
+
 // CreateCheckTime is the duration to delay between successive checks for successful VM creation.
 var CreateCheckTime = 60 * time.Second
 
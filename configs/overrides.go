@@ -0,0 +1,82 @@
+package configs
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ApplySettings overrides the fields of config, a pointer to a struct with json tags, with settings of the form
+// "key=value", as given with the --set flag. An unknown key is an error.
+func ApplySettings(config interface{}, settings []string) error {
+	_, err := applySettings(config, settings)
+	return err
+}
+
+// applySettings is ApplySettings, returning the keys set.
+func applySettings(config interface{}, settings []string) ([]string, error) {
+	var set []string
+	for _, setting := range settings {
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid setting %q, expected key=value", setting)
+		}
+		field, ok := fieldByKey(config, parts[0])
+		if !ok {
+			return nil, fmt.Errorf("unknown configuration key %q", parts[0])
+		}
+		if err := setField(field, parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid setting %q, %v", setting, err)
+		}
+		set = append(set, parts[0])
+	}
+	return set, nil
+}
+
+// fieldByKey returns the field of the struct config points to whose json tag is key, including the fields of
+// embedded structs, as the JSON encoding does.
+func fieldByKey(config interface{}, key string) (reflect.Value, bool) {
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if embedded, ok := fieldByKey(value.Field(i).Addr().Interface(), key); ok {
+				return embedded, true
+			}
+			continue
+		}
+		if jsonKey(field) == key {
+			return value.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// jsonKey returns the key of a field in the JSON encoding, "" if it has none.
+func jsonKey(field reflect.StructField) string {
+	tag := strings.Split(field.Tag.Get("json"), ",")[0]
+	switch {
+	case tag == "-":
+		return ""
+	case tag == "":
+		return field.Name
+	default:
+		return tag
+	}
+}
+
+// setField sets field from value, decoded as JSON when it is valid JSON for the field, e.g. a number or true, and as
+// a JSON string otherwise, so a Duration can be given as "30s".
+func setField(field reflect.Value, value string) error {
+	target := reflect.New(field.Type())
+	if field.Kind() == reflect.String || json.Unmarshal([]byte(value), target.Interface()) != nil {
+		data, _ := json.Marshal(value)
+		target = reflect.New(field.Type())
+		if err := json.Unmarshal(data, target.Interface()); err != nil {
+			return err
+		}
+	}
+	field.Set(target.Elem())
+	return nil
+}
@@ -0,0 +1,67 @@
+package configs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Files are the configuration files of a daemon.
+type Files struct {
+	// Name is the configuration file, "" for none.
+	Name string
+
+	// Profiles is the pathname, without an extension, the profiles of the environments are found at:
+	// <Profiles>.<environment>.json, .yaml, .yml or .toml. "" for none.
+	Profiles string
+}
+
+// ProfileFile returns the profile of environment, the file overlaying the base configuration in that environment, or
+// "" if there is none.
+func (files Files) ProfileFile(environment string) string {
+	if files.Profiles == "" {
+		return ""
+	}
+	return FindFile(filepath.Dir(files.Profiles), filepath.Base(files.Profiles)+"."+environment)
+}
+
+// commoner is implemented by the configurations that embed Common.
+type commoner interface {
+	common() *Common
+}
+
+// common returns the common settings of a configuration that embeds them.
+func (c *Common) common() *Common {
+	return c
+}
+
+// checkEnvironment returns an error if environment isn't one of Environments.
+func checkEnvironment(environment string) error {
+	for _, name := range Environments {
+		if environment == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown environment %q, use one of %v", environment, strings.Join(Environments, ", "))
+}
+
+// Validate returns an error describing the first broken rule of the common settings:
+//   - the environment must be one of Environments;
+//   - DryRun can only be false in the prod environment, unless DryRunOverride is set;
+//   - ProdLogDebug only applies to the prod environment, every other environment logs at debug level.
+func (c Common) Validate() error {
+	if err := checkEnvironment(c.Environment); err != nil {
+		return err
+	}
+	if c.Environment != EnvironmentProd {
+		if !c.DryRun && !c.DryRunOverride {
+			return fmt.Errorf("dry_run can only be false in the %v environment, not %v, unless dry_run_override is set",
+				EnvironmentProd, c.Environment)
+		}
+		if c.ProdLogDebug {
+			return fmt.Errorf("prod_log_debug only applies to the %v environment, not %v", EnvironmentProd,
+				c.Environment)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package configs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// profileConfig is the configuration of the profile tests.
+type profileConfig struct {
+	Common
+	Message string `json:"message" env:"MESSAGE"`
+}
+
+// TestCommonValidate checks the rules tying the environment, DryRun and the log level together.
+func TestCommonValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		common Common
+		err    string
+	}{
+		{"defaults", DefaultCommon(), ""},
+		{"local dry run", Common{Environment: EnvironmentLocal, DryRun: true}, ""},
+		{"prod dry run", Common{Environment: EnvironmentProd, DryRun: true}, ""},
+		{"prod live", Common{Environment: EnvironmentProd}, ""},
+		{"prod debug", Common{Environment: EnvironmentProd, ProdLogDebug: true}, ""},
+		{"local live with override", Common{Environment: EnvironmentLocal, DryRunOverride: true}, ""},
+		{"unknown", Common{Environment: "produciton", DryRun: true}, `unknown environment "produciton"`},
+		{"empty", Common{DryRun: true}, `unknown environment ""`},
+		{"upper case", Common{Environment: "PROD"}, `unknown environment "PROD"`},
+		{"local live", Common{Environment: EnvironmentLocal}, "dry_run can only be false in the prod environment"},
+		{"local debug", Common{Environment: EnvironmentLocal, DryRun: true, ProdLogDebug: true},
+			"prod_log_debug only applies to the prod environment"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.common.Validate()
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Validate() = %v, want an error with %q", err, test.err)
+			}
+		})
+	}
+}
+
+// TestLoadProfile checks that the environment, wherever it is set, selects the profile overlaid on the configuration
+// file, below the environment variables and flags.
+func TestLoadProfile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.json")
+	writeFile(t, name, `{"message": "base"}`)
+	writeFile(t, filepath.Join(dir, "app.prod.json"), `{"message": "prod", "dry_run": false}`)
+	files := Files{Name: name, Profiles: filepath.Join(dir, "app")}
+
+	tests := []struct {
+		name     string
+		file     string
+		environ  []string
+		settings []string
+		want     profileConfig
+		sources  Sources
+	}{
+		{"no profile", "", nil, nil, profileConfig{Common: DefaultCommon(), Message: "base"},
+			Sources{"environment": SourceDefault, "message": SourceFile}},
+		{"environment variable", "", []string{"GO_DAEMONS_ENVIRON=prod"}, nil,
+			profileConfig{Common: Common{Environment: EnvironmentProd}, Message: "prod"},
+			Sources{"dry_run": SourceProfile, "environment": SourceEnv, "message": SourceProfile}},
+		{"flag", "", nil, []string{"environment=prod", "message=flag"},
+			profileConfig{Common: Common{Environment: EnvironmentProd}, Message: "flag"},
+			Sources{"dry_run": SourceProfile, "environment": SourceFlag, "message": SourceFlag}},
+		{"configuration file", `{"environment": "prod"}`, []string{"GO_DAEMONS_MESSAGE=env"}, nil,
+			profileConfig{Common: Common{Environment: EnvironmentProd}, Message: "env"},
+			Sources{"dry_run": SourceProfile, "environment": SourceFile, "message": SourceEnv}},
+		{"upper case", "", []string{"GO_DAEMONS_ENVIRON=PROD"}, nil,
+			profileConfig{Common: Common{Environment: EnvironmentProd}, Message: "prod"},
+			Sources{"environment": SourceEnv, "message": SourceProfile}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := files
+			if test.file != "" {
+				files.Name = writeConfigFile(t, "app.json", test.file)
+			}
+			config := profileConfig{Common: DefaultCommon()}
+			sources, err := Load(&config, files, test.environ, test.settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config, test.want) {
+				t.Errorf("Load() = %+v, want %+v", config, test.want)
+			}
+			for key, source := range test.sources {
+				if sources[key] != source {
+					t.Errorf("the source of %v is %v, want %v", key, sources[key], source)
+				}
+			}
+		})
+	}
+}
+
+// TestLoadProfileErrors checks that an unknown environment is rejected wherever it is set, and that a profile can't
+// change the environment.
+func TestLoadProfileErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "app.prod.json"), `{"environment": "local"}`)
+	writeFile(t, filepath.Join(dir, "app.local.json"), `{"environment": "LOCAL"}`)
+	files := Files{Profiles: filepath.Join(dir, "app")}
+
+	tests := []struct {
+		name     string
+		file     string
+		environ  []string
+		settings []string
+		err      string
+	}{
+		{"unknown in the environment", "", []string{"GO_DAEMONS_ENVIRON=produciton"}, nil,
+			`unknown environment "produciton"`},
+		{"unknown in a flag", "", nil, []string{"environment=gcp"}, `unknown environment "gcp"`},
+		{"unknown in the file", `{"environment": "staging"}`, nil, nil, `unknown environment "staging"`},
+		{"empty", "", nil, []string{"environment="}, `unknown environment ""`},
+		{"profile changes the environment", `{"environment": "prod"}`, nil, nil, "cannot change the environment"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := files
+			if test.file != "" {
+				files.Name = writeConfigFile(t, "app.json", test.file)
+			}
+			config := profileConfig{Common: DefaultCommon()}
+			if _, err := Load(&config, files, test.environ, test.settings); err == nil ||
+				!strings.Contains(err.Error(), test.err) {
+				t.Errorf("Load() = %v, want an error with %q", err, test.err)
+			}
+		})
+	}
+
+	// A profile may set the environment it is the profile of, in any case.
+	config := profileConfig{Common: DefaultCommon()}
+	if _, err := Load(&config, files, nil, nil); err != nil {
+		t.Errorf("Load() with the profile of its own environment = %v", err)
+	}
+	if config.Environment != EnvironmentLocal {
+		t.Errorf("the environment is %q, want %q", config.Environment, EnvironmentLocal)
+	}
+}
+
+// TestSchemaEnvironment checks that the schema of a configuration embedding Common only accepts the names of the
+// environments.
+func TestSchemaEnvironment(t *testing.T) {
+	properties := Schema("app", &profileConfig{Common: DefaultCommon()})["properties"].(map[string]interface{})
+	environment := properties["environment"].(map[string]interface{})
+	if !reflect.DeepEqual(environment["enum"], Environments) {
+		t.Errorf("the environment enum is %v, want %v", environment["enum"], Environments)
+	}
+	if environment["default"] != EnvironmentLocal {
+		t.Errorf("the environment default is %v, want %v", environment["default"], EnvironmentLocal)
+	}
+
+	var other struct {
+		Environment string `json:"environment"`
+	}
+	properties = Schema("other", &other)["properties"].(map[string]interface{})
+	if enum, ok := properties["environment"].(map[string]interface{})["enum"]; ok {
+		t.Errorf("the environment of a configuration without Common has the enum %v", enum)
+	}
+}
+
+// writeFile writes content to the file name, for the files that have to be in the same directory.
+func writeFile(t *testing.T, name string, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
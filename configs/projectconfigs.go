@@ -1,7 +1,9 @@
 package configs
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -11,11 +13,34 @@ import (
 // *** Common ***
 // *****************************
 
+// NOTE: the daemons are started with a controlled environment, the variables of EnvFile and a short allowlist of the
+// inherited ones (see daemon.SetEnvironment), so the GO_DAEMONS_* variables read below don't depend on the shell of
+// whoever ran "<daemon> start".
+
+// Common are the settings shared by every daemon. They are part of the configuration of each daemon, so they are read
+// from the same configuration file, GO_DAEMONS_* variables and --set flags; see SetCommon.
+type Common struct {
+	// DryRun, see the package-level var.
+	DryRun bool `json:"dry_run" env:"DRY_RUN"`
+
+	// Environment, see the package-level var.
+	Environment string `json:"environment" env:"ENVIRON"`
+
+	// ProdLogDebug, see the package-level var.
+	ProdLogDebug bool `json:"prod_log_debug" env:"PROD_LOG_DEBUG"`
+
+	// DryRunOverride allows DryRun to be false outside the prod environment, e.g. to test against a sandbox.
+	DryRunOverride bool `json:"dry_run_override" env:"DRY_RUN_OVERRIDE"`
+}
+
+// initialCommon are the common settings read from the process environment when the package is initialised.
+var initialCommon = commonFromEnv()
+
 // DryRun is used to protect and prevent external calls from executing while not in the Live environment.
-var DryRun = GetBoolEnvVar("GO_DAEMONS_DRY_RUN", true)
+var DryRun = initialCommon.DryRun
 
-// Environment is the environment we are running in: PROD | GCP | LOCAL
-var Environment = getEnvironment()
+// Environment is the environment we are running in, one of Environments.
+var Environment = initialCommon.Environment
 
 // EnvironmentLocal is the environment variable for LOCAL
 var EnvironmentLocal = "local"
@@ -23,6 +48,16 @@ var EnvironmentLocal = "local"
 // EnvironmentProd is the environment variable for PROD
 var EnvironmentProd = "prod"
 
+// Environments are the names of the environments, the values accepted for Environment. Each can have a profile, see
+// Files.
+var Environments = []string{EnvironmentLocal, EnvironmentProd}
+
+// ConfigPath is the absolute directory where the daemon configuration files are looked for, e.g. helloworld.yaml.
+const ConfigPath = "/etc/godaemons"
+
+// EnvFile is the environment file the daemons are started with. It is optional; without it the defaults below apply.
+const EnvFile = ConfigPath + "/godaemons.env"
+
 // HeartBeatTime is the time in between heart beat notifications printed in the log files.
 const HeartBeatTime = 2 * time.Minute
 
@@ -34,14 +69,19 @@ var Live = Environment == EnvironmentProd
 
 // ProdLogDebug is used to force debug level logs in production.
 // If true and Live then logs at Debug level else if false and Live then Info level.
-var ProdLogDebug = GetBoolEnvVar("GO_DAEMONS_PROD_LOG_DEBUG", false)
+var ProdLogDebug = initialCommon.ProdLogDebug
 
-// LogPath is the absolute directory where the HelloWorld log files are located at.
+// LogPath is the default absolute directory where the daemon log files are located at when run as root, "log_dir" in
+// the daemon configuration files. See DefaultLogPath.
 const LogPath = "/var/log/godaemons"
 
-// PidPath is the absolute directory where the HelloWorld PID files are located at.
+// PidPath is the default absolute directory where the daemon PID files are located at when run as root, "pid_dir" in
+// the daemon configuration files. See DefaultPidPath.
 const PidPath = "/var/run/godaemons"
 
+// userDir is the name of the directory of the project in the XDG directories of a user.
+const userDir = "godaemons"
+
 // UIURL is the URL to the HelloWorld Site (UIs).
 const UIURL = ""
 
@@ -50,8 +90,84 @@ const TestLogName = "testing.log"
 
 // *** Setters ***
 
+// DefaultCommon returns the common settings used when neither the configuration file nor the environment sets them.
+func DefaultCommon() Common {
+	return Common{
+		DryRun:       true,
+		Environment:  EnvironmentLocal,
+		ProdLogDebug: false,
+	}
+}
+
+// SetCommon replaces the package-level vars with the common settings of the daemon's configuration. It is called once
+// the configuration has been loaded, before the worker starts; a reload doesn't change them.
+func SetCommon(common Common) {
+	DryRun = common.DryRun
+	Environment = strings.ToLower(common.Environment)
+	Live = Environment == EnvironmentProd
+	ProdLogDebug = common.ProdLogDebug
+}
+
+// DefaultLogPath returns the directory of the log files when the configuration doesn't set one: LogPath for root, and
+// for any other user $XDG_STATE_HOME/godaemons, ~/.local/state/godaemons by default, so a daemon can run without
+// privileges. The directory is created when the daemon starts.
+//
+// NOTE: the directory is resolved once, when the package is initialised, so a daemon that drops its privileges keeps
+// the directory it was started with, on reloads too.
+func DefaultLogPath() string {
+	return defaultLogPath
+}
+
+// DefaultPidPath returns the directory of the PID files when the configuration doesn't set one: PidPath for root,
+// and for any other user $XDG_RUNTIME_DIR/godaemons, which is private to the user and cleared when they log out for
+// the last time. The directory is created when the daemon starts. It is resolved once, as for DefaultLogPath.
+func DefaultPidPath() string {
+	return defaultPidPath
+}
+
+// defaultLogPath and defaultPidPath are the directories returned by DefaultLogPath and DefaultPidPath.
+var defaultLogPath, defaultPidPath = resolveLogPath(), resolvePidPath()
+
+// resolveLogPath returns the directory of the log files of the current user, see DefaultLogPath.
+func resolveLogPath() string {
+	if os.Geteuid() == 0 {
+		return LogPath
+	}
+	state := os.Getenv("XDG_STATE_HOME")
+	if !filepath.IsAbs(state) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return userTempDir()
+		}
+		state = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(state, userDir)
+}
+
+// resolvePidPath returns the directory of the PID files of the current user, see DefaultPidPath.
+func resolvePidPath() string {
+	if os.Geteuid() == 0 {
+		return PidPath
+	}
+	runtime := os.Getenv("XDG_RUNTIME_DIR")
+	if !filepath.IsAbs(runtime) {
+		// Outside a login session, e.g. in cron, there is no runtime directory.
+		return userTempDir()
+	}
+	return filepath.Join(runtime, userDir)
+}
+
+// userTempDir returns the directory used for the files of a user who has no XDG directory for them. Its name can be
+// guessed, so the daemon creates it with mode 0700 and refuses one that another user created first.
+func userTempDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("%v-%v", userDir, os.Geteuid()))
+}
+
 // GetBoolEnvVar will get the environment variable for envVarName and attempt to cast it to a bool.
 // If it fails or does not exist then uses the defaultValue.
+//
+// Deprecated: a typo in the value silently gives the default, use a struct field with an env tag and LoadEnv, which
+// reports it.
 func GetBoolEnvVar(envVarName string, defaultValue bool) bool {
 	result := defaultValue
 	value, exists := os.LookupEnv(envVarName)
@@ -64,13 +180,11 @@ func GetBoolEnvVar(envVarName string, defaultValue bool) bool {
 	return result
 }
 
-// getEnvironment is a setter for Environment.
-func getEnvironment() string {
-	// Default to true.
-	environment := EnvironmentLocal
-	value, exists := os.LookupEnv("GO_DAEMONS_ENVIRON")
-	if exists {
-		environment = strings.ToLower(value)
-	}
-	return environment
+// commonFromEnv is a setter for initialCommon. The invalid variables are left at their defaults here, the daemon
+// reports them when it loads its configuration.
+func commonFromEnv() Common {
+	common := DefaultCommon()
+	_ = LoadEnv(os.Environ(), &common)
+	common.Environment = strings.ToLower(common.Environment)
+	return common
 }
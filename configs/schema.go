@@ -0,0 +1,116 @@
+package configs
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+)
+
+// SchemaDraft is the JSON Schema version written by Schema.
+const SchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// Schema returns the JSON Schema of the configuration file of the struct config points to, for editors to check and
+// complete the file with. The values config holds are the defaults of the properties, the min and max tags of the
+// numbers, strings and lists become their bounds, and as for ReadFile unknown keys are not allowed. When config embeds
+// Common, the environment is one of Environments.
+func Schema(title string, config interface{}) map[string]interface{} {
+	schema := schemaFor(reflect.TypeOf(config).Elem())
+	schema["$schema"] = SchemaDraft
+	schema["title"] = title
+
+	properties := schema["properties"].(map[string]interface{})
+	if _, ok := config.(commoner); ok {
+		if property, ok := properties["environment"].(map[string]interface{}); ok {
+			property["enum"] = Environments
+		}
+	}
+
+	var defaults map[string]interface{}
+	if data, err := json.Marshal(config); err == nil && json.Unmarshal(data, &defaults) == nil {
+		for key, value := range defaults {
+			if property, ok := properties[key].(map[string]interface{}); ok {
+				property["default"] = value
+			}
+		}
+	}
+	return schema
+}
+
+// schemaFor returns the schema of the values of type t.
+func schemaFor(t reflect.Type) map[string]interface{} {
+	switch t {
+	case durationType, configDurationType:
+		// A Duration is read from a string such as "30s", or a number of nanoseconds.
+		return map[string]interface{}{"type": []string{"string", "integer"}, "pattern": `^([0-9.]+(ns|us|µs|ms|s|m|h))+$`}
+	case urlType:
+		return map[string]interface{}{"type": "string", "format": "uri"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Ptr:
+		return schemaFor(t.Elem())
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		addProperties(t, properties)
+		return map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// addProperties adds the schemas of the fields of the struct type t to properties, including the fields of embedded
+// structs.
+func addProperties(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			addProperties(field.Type, properties)
+			continue
+		}
+		key := jsonKey(field)
+		if key == "" || field.PkgPath != "" {
+			continue
+		}
+
+		property := schemaFor(field.Type)
+		if field.Type != durationType && field.Type != configDurationType {
+			addBounds(property, field.Tag)
+		}
+		properties[key] = property
+	}
+}
+
+// addBounds adds the min and max tags of a field to its schema.
+func addBounds(property map[string]interface{}, tag reflect.StructTag) {
+	names := map[string][2]string{
+		"integer": {"minimum", "maximum"},
+		"number":  {"minimum", "maximum"},
+		"string":  {"minLength", "maxLength"},
+		"array":   {"minItems", "maxItems"},
+		"object":  {"minProperties", "maxProperties"},
+	}
+	kind, _ := property["type"].(string)
+	for i, bound := range []string{"min", "max"} {
+		limit, ok := tag.Lookup(bound)
+		if !ok || names[kind][i] == "" {
+			continue
+		}
+		if value, err := strconv.ParseFloat(limit, 64); err == nil {
+			property[names[kind][i]] = value
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package configs
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// schemaEmbedded is embedded in schemaConfig, its fields are properties of schemaConfig.
+type schemaEmbedded struct {
+	Region string `json:"region"`
+}
+
+// schemaConfig is the configuration of the schema tests.
+type schemaConfig struct {
+	schemaEmbedded
+	Name     string            `json:"name" min:"1" max:"8"`
+	Workers  int               `json:"workers" min:"1" max:"16"`
+	Port     uint16            `json:"port"`
+	Ratio    float64           `json:"ratio" min:"0" max:"1"`
+	Debug    bool              `json:"debug"`
+	Timeout  time.Duration     `json:"timeout" min:"1s"`
+	Interval Duration          `json:"interval"`
+	Endpoint *url.URL          `json:"endpoint"`
+	Tags     []string          `json:"tags" max:"3"`
+	Labels   map[string]string `json:"labels"`
+	Server   struct {
+		Host string `json:"host"`
+	} `json:"server"`
+	Ignored  string `json:"-"`
+	internal string
+}
+
+// TestSchema checks the JSON Schema of a configuration: a property per key with its type, bounds and default, and no
+// unknown keys.
+func TestSchema(t *testing.T) {
+	config := schemaConfig{Name: "app", Workers: 2, Timeout: time.Second, Tags: []string{"a"}}
+	config.Region = "eu"
+	config.internal = "hidden"
+	data, err := json.Marshal(Schema("app", &config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	duration := `"type": ["string", "integer"], "pattern": "^([0-9.]+(ns|us|µs|ms|s|m|h))+$"`
+	want := `{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"title": "app",
+		"type": "object",
+		"additionalProperties": false,
+		"properties": {
+			"region": {"type": "string", "default": "eu"},
+			"name": {"type": "string", "minLength": 1, "maxLength": 8, "default": "app"},
+			"workers": {"type": "integer", "minimum": 1, "maximum": 16, "default": 2},
+			"port": {"type": "integer", "minimum": 0, "default": 0},
+			"ratio": {"type": "number", "minimum": 0, "maximum": 1, "default": 0},
+			"debug": {"type": "boolean", "default": false},
+			"timeout": {` + duration + `, "default": 1000000000},
+			"interval": {` + duration + `, "default": "0s"},
+			"endpoint": {"type": "string", "format": "uri", "default": null},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 3, "default": ["a"]},
+			"labels": {"type": "object", "additionalProperties": {"type": "string"}, "default": null},
+			"server": {"type": "object", "additionalProperties": false, "properties": {"host": {"type": "string"}},
+				"default": {"host": ""}}
+		}
+	}`
+
+	var schema, wantSchema interface{}
+	if err = json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal([]byte(want), &wantSchema); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(schema, wantSchema) {
+		t.Errorf("Schema() = %s, want %s", data, want)
+	}
+}
@@ -0,0 +1,183 @@
+package configs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Source is the layer the value of a configuration key comes from.
+type Source string
+
+// The layers of a configuration, in increasing order of precedence.
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceProfile Source = "profile"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// redactedValue replaces the value of a secret in the output of Show.
+const redactedValue = "********"
+
+// Sources are the sources of the top level keys of a configuration, by key.
+type Sources map[string]Source
+
+// Load overrides the defaults config points to with, in increasing order of precedence, the configuration file
+// files.Name, the profile of the environment, the GO_DAEMONS_* variables of environ, and settings, the "key=value"
+// values of --set; see ReadFile, LoadEnv and ApplySettings. The source of every key is returned, for
+// "<daemon> config show".
+//
+// NOTE: when config embeds Common, its environment, wherever it was set, selects the profile, see Files; its name is
+// lower cased, and an unknown name is an error. A profile can't change the environment.
+func Load(config interface{}, files Files, environ []string, settings []string) (Sources, error) {
+	defaults := reflect.ValueOf(config).Elem().Interface()
+	sources, err := loadLayers(config, files.Name, "", environ, settings)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := config.(commoner)
+	if !ok {
+		return sources, nil
+	}
+
+	environment := strings.ToLower(c.common().Environment)
+	if err = checkEnvironment(environment); err != nil {
+		return nil, err
+	}
+	c.common().Environment = environment
+	profile := files.ProfileFile(environment)
+	if profile == "" {
+		return sources, nil
+	}
+
+	// The profile goes below the environment variables and flags, so everything is loaded again with it.
+	reflect.ValueOf(config).Elem().Set(reflect.ValueOf(defaults))
+	if sources, err = loadLayers(config, files.Name, profile, environ, settings); err != nil {
+		return nil, err
+	}
+	if strings.ToLower(c.common().Environment) != environment {
+		return nil, fmt.Errorf("the profile %v cannot change the environment", profile)
+	}
+	c.common().Environment = environment
+	return sources, nil
+}
+
+// loadLayers is Load without the profile lookup. Empty file names are skipped.
+func loadLayers(config interface{}, name string, profile string, environ []string,
+	settings []string) (Sources, error) {
+	sources := make(Sources)
+	for _, key := range keys(reflect.TypeOf(config).Elem()) {
+		sources[key] = SourceDefault
+	}
+
+	for _, file := range []struct {
+		name   string
+		source Source
+	}{{name, SourceFile}, {profile, SourceProfile}} {
+		if file.name == "" {
+			continue
+		}
+		set, err := readFile(file.name, config)
+		if err != nil {
+			return nil, err
+		}
+		sources.record(file.source, set)
+	}
+	set, err := loadEnv(environ, config)
+	if err != nil {
+		return nil, err
+	}
+	sources.record(SourceEnv, set)
+	if set, err = applySettings(config, settings); err != nil {
+		return nil, err
+	}
+	sources.record(SourceFlag, set)
+	return sources, nil
+}
+
+// record sets the source of the keys set by a layer.
+func (sources Sources) record(source Source, set []string) {
+	for _, key := range set {
+		sources[key] = source
+	}
+}
+
+// keys returns the top level JSON keys of the struct type t, including the ones of embedded structs.
+func keys(t reflect.Type) []string {
+	var result []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			result = append(result, keys(field.Type)...)
+		} else if key := jsonKey(field); key != "" && field.PkgPath == "" {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// secretKeys returns the top level JSON keys of the fields of the struct type t tagged secret:"true".
+func secretKeys(t reflect.Type, secrets map[string]bool) map[string]bool {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			secretKeys(field.Type, secrets)
+		} else if field.Tag.Get("secret") == "true" {
+			secrets[jsonKey(field)] = true
+		}
+	}
+	return secrets
+}
+
+// ShownKey is a key of the configuration written by Show as JSON.
+type ShownKey struct {
+	Value  json.RawMessage `json:"value"`
+	Source Source          `json:"source"`
+}
+
+// Show writes the effective configuration config points to, a key per line with its value, as JSON, and its source,
+// sorted by key. asJSON writes a JSON object of ShownKey instead. The values of the fields tagged secret:"true" are
+// redacted.
+func Show(w io.Writer, config interface{}, sources Sources, asJSON bool) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	var values map[string]json.RawMessage
+	if err = json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	secrets := secretKeys(reflect.TypeOf(config).Elem(), make(map[string]bool))
+	shown := make(map[string]ShownKey, len(values))
+	names := make([]string, 0, len(values))
+	for key, value := range values {
+		if secrets[key] {
+			value, _ = json.Marshal(redactedValue)
+		}
+		source := sources[key]
+		if source == "" {
+			source = SourceDefault
+		}
+		shown[key] = ShownKey{Value: value, Source: source}
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(shown)
+	}
+	for _, key := range names {
+		if _, err = fmt.Fprintf(w, "  %-26v %-30s %v\n", key, shown[key].Value, shown[key].Source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package configs
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// sourceConfig is the configuration of the source attribution tests.
+type sourceConfig struct {
+	Common
+	Message  string `json:"message" env:"MESSAGE"`
+	Workers  int    `json:"workers" env:"WORKERS"`
+	Region   string `json:"region" env:"REGION"`
+	Password string `json:"password" env:"PASSWORD" secret:"true"`
+	Host     string `json:"host" env:"HOST"`
+}
+
+// TestLoadSources checks that the source of every key is the highest layer that set it: the defaults, the
+// configuration file, the profile, the environment variables, then the --set flags.
+func TestLoadSources(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.json")
+	writeFile(t, name, `{"environment": "prod", "message": "file", "workers": 2, "region": "eu", "host": "file"}`)
+	writeFile(t, filepath.Join(dir, "app.prod.json"), `{"dry_run": false, "workers": 4, "region": "us"}`)
+	environ := []string{"GO_DAEMONS_REGION=ap", "GO_DAEMONS_HOST=env", "UNRELATED=1"}
+
+	config := sourceConfig{Common: DefaultCommon(), Password: "default"}
+	sources, err := Load(&config, Files{Name: name, Profiles: filepath.Join(dir, "app")}, environ,
+		[]string{"host=flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := sourceConfig{Common: Common{Environment: EnvironmentProd}, Message: "file", Workers: 4, Region: "ap",
+		Password: "default", Host: "flag"}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("Load() = %+v, want %+v", config, want)
+	}
+	wantSources := Sources{
+		"dry_run":          SourceProfile,
+		"dry_run_override": SourceDefault,
+		"environment":      SourceFile,
+		"host":             SourceFlag,
+		"message":          SourceFile,
+		"password":         SourceDefault,
+		"prod_log_debug":   SourceDefault,
+		"region":           SourceEnv,
+		"workers":          SourceProfile,
+	}
+	if !reflect.DeepEqual(sources, wantSources) {
+		t.Errorf("Load() sources = %v, want %v", sources, wantSources)
+	}
+}
+
+// TestShow checks the effective configuration written by "config show", as text and as JSON, with the secrets
+// redacted.
+func TestShow(t *testing.T) {
+	config := sourceConfig{Common: DefaultCommon(), Message: "hello", Workers: 4, Password: "hunter2"}
+	sources := Sources{"message": SourceFile, "workers": SourceEnv, "password": SourceFlag, "region": SourceProfile}
+
+	var text bytes.Buffer
+	if err := Show(&text, &config, sources, false); err != nil {
+		t.Fatal(err)
+	}
+	want := `  dry_run                    true                           default
+  dry_run_override           false                          default
+  environment                "local"                        default
+  host                       ""                             default
+  message                    "hello"                        file
+  password                   "********"                     flag
+  prod_log_debug             false                          default
+  region                     ""                             profile
+  workers                    4                              env
+`
+	if text.String() != want {
+		t.Errorf("Show() wrote\n%v\nwant\n%v", text.String(), want)
+	}
+	if bytes.Contains(text.Bytes(), []byte("hunter2")) {
+		t.Errorf("Show() wrote the secret")
+	}
+
+	var data bytes.Buffer
+	if err := Show(&data, &config, sources, true); err != nil {
+		t.Fatal(err)
+	}
+	var shown map[string]ShownKey
+	if err := json.Unmarshal(data.Bytes(), &shown); err != nil {
+		t.Fatalf("Show() wrote invalid JSON, %v", err)
+	}
+	for key, want := range map[string]struct {
+		value  string
+		source Source
+	}{
+		"dry_run":  {"true", SourceDefault},
+		"message":  {`"hello"`, SourceFile},
+		"password": {`"********"`, SourceFlag},
+		"workers":  {"4", SourceEnv},
+	} {
+		if string(shown[key].Value) != want.value || shown[key].Source != want.source {
+			t.Errorf("Show() wrote %v = %s from %v, want %s from %v", key, shown[key].Value, shown[key].Source,
+				want.value, want.source)
+		}
+	}
+	if len(shown) != 9 {
+		t.Errorf("Show() wrote %v keys, want 9", len(shown))
+	}
+	if bytes.Contains(data.Bytes(), []byte("hunter2")) {
+		t.Errorf("Show() wrote the secret as JSON")
+	}
+}
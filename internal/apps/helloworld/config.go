@@ -0,0 +1,86 @@
+package helloworld
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/go-daemons/configs"
+	"github.com/go-daemons/configs/helloworldconfigs"
+	"github.com/go-daemons/pkg/daemon"
+)
+
+// configCommand returns the "config" command of the HelloWorld daemon:
+//   - "config show [--json]" prints the effective configuration, with the source of every key: default, file, env or
+//     flag. It is the configuration "start" would give the daemon now.
+//   - "config validate [--file name]" checks a configuration file, by default the daemon's, before it is deployed.
+//   - "config schema" prints the JSON Schema of the configuration file, for editors.
+//
+// The command runs without the daemon, and reports a broken configuration with exit code 6.
+func configCommand() daemon.Command {
+	var file string
+	var asJSON bool
+	return daemon.Command{
+		Name:     "config",
+		Synopsis: "show, validate or print the JSON Schema of the configuration: config show|validate|schema",
+		SetFlags: func(flags *flag.FlagSet) {
+			flags.StringVar(&file, "file", "", "configuration `file` to show or validate, instead of the daemon's")
+			flags.BoolVar(&asJSON, "json", false, "show the configuration as JSON")
+		},
+		Run: func(ctx *daemon.Context, flags *flag.FlagSet) error {
+			action := flags.Arg(0)
+			if flags.NArg() > 0 {
+				// The flags may also follow the action.
+				if err := flags.Parse(flags.Args()[1:]); err != nil {
+					return &daemon.ExitError{Code: daemon.ExitInvalidArgument, Err: err}
+				}
+			}
+			if flags.NArg() > 0 {
+				return &daemon.ExitError{Code: daemon.ExitInvalidArgument,
+					Err: fmt.Errorf("unexpected arguments %v", flags.Args())}
+			}
+
+			if file == "" {
+				file = ctx.Options().ConfigFile
+			}
+			files := helloworldconfigs.ConfigFiles(file)
+			from := files.Name
+			if from == "" {
+				from = "the defaults, there is no configuration file"
+			}
+
+			switch action {
+			case "show", "validate":
+				config, sources, err := helloworldconfigs.LoadConfig(files, ctx.Environ(), ctx.Options().Settings)
+				if err != nil {
+					return &daemon.ExitError{Code: daemon.ExitNotConfigured,
+						Err: fmt.Errorf("invalid configuration, %v", err)}
+				}
+				if profile := files.ProfileFile(config.Environment); profile != "" {
+					from += ", with the " + config.Environment + " profile " + profile
+				}
+				if action == "validate" {
+					fmt.Printf("Configuration of %v from %v is valid\n", helloworldconfigs.AppName, from)
+					return nil
+				}
+				if !asJSON {
+					fmt.Printf("Configuration of %v from %v:\n", helloworldconfigs.AppName, from)
+				}
+				return configs.Show(os.Stdout, config, sources, asJSON)
+			case "schema":
+				schema := configs.Schema(helloworldconfigs.AppName+" configuration", helloworldconfigs.DefaultConfig())
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(schema)
+			case "":
+				return &daemon.ExitError{Code: daemon.ExitInvalidArgument,
+					Err: fmt.Errorf("missing config action, use show, validate or schema")}
+			default:
+				return &daemon.ExitError{Code: daemon.ExitInvalidArgument,
+					Err: fmt.Errorf("unknown config action %q, use show, validate or schema", action)}
+			}
+		},
+		SkipPaths: true,
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/go-daemons/configs"
+	"github.com/go-daemons/configs/helloworldconfigs"
 	"github.com/go-daemons/internal/pkg/context"
 	"github.com/go-daemons/internal/pkg/utils"
 )
@@ -15,6 +16,6 @@ func Daemon(ctx context.Context) error {
 		ctx.SetHeartBeat(time.Now().UTC())
 		ctx.GetLogger().Info("Daemon orchestration heartbeat")
 	}
-	ctx.GetLogger().Info("****Hello world******")
+	ctx.GetLogger().Info(ctx.GetConfig().(*helloworldconfigs.Config).Message)
 	return nil
 }
@@ -0,0 +1,119 @@
+package helloworld
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/go-daemons/configs"
+	"github.com/go-daemons/configs/helloworldconfigs"
+	appcontext "github.com/go-daemons/internal/pkg/context"
+	"github.com/go-daemons/internal/pkg/logutil"
+	"github.com/go-daemons/internal/pkg/utils"
+	"github.com/go-daemons/pkg/daemon"
+)
+
+// NewDaemon allocates the daemon context for the HelloWorld daemon, with its PID file, log file, worker and
+// policies. The version is the version of the binary the daemon is built into. Pass the result to
+// daemon.ProcessCommandLine, or add it to a daemon.Host. The paths passed to New are the defaults, the configuration
+// decides the ones used once the command line has been parsed.
+func NewDaemon(version string) *daemon.Context {
+	ctx := &daemon.Context{}
+	logFile := filepath.Join(configs.DefaultLogPath(), helloworldconfigs.LogName)
+	_ = ctx.New(helloworldconfigs.PidFile, logFile, helloworldconfigs.WorkingDir, helloworldconfigs.AppName)
+	ctx.SetVersion(version)
+	ctx.SetEnvironment(daemon.Environment{File: "-" + configs.EnvFile})
+	appCtx := &appcontext.AppContext{}
+	load := func() (*helloworldconfigs.Config, error) {
+		options := ctx.Options()
+		config, _, err := helloworldconfigs.LoadConfig(helloworldconfigs.ConfigFiles(options.ConfigFile),
+			ctx.Environ(), options.Settings)
+		return config, err
+	}
+	ctx.SetPathsHandler(func(_ daemon.Options) (daemon.Paths, error) {
+		config, err := load()
+		if err != nil {
+			return daemon.Paths{}, err
+		}
+		return daemon.Paths{PidFile: config.PidFile(), LogFile: config.LogFile(), WorkingDir: config.WorkingDir,
+			SystemPidFile: config.SystemPidFile()}, nil
+	})
+	ctx.SetConfigLoader(func() (interface{}, error) {
+		return load()
+	}, func(config interface{}, changed []string) {
+		if changed == nil {
+			// The common settings are only read when the daemon starts.
+			configs.SetCommon(config.(*helloworldconfigs.Config).Common)
+		}
+		appCtx.SetConfig(config)
+	})
+	ctx.AddCommand(configCommand())
+	ctx.SetRunHandler(worker(ctx, appCtx))
+	ctx.SetSupervisor(daemon.DefaultSupervisorPolicy())
+	ctx.SetPanicPolicy(daemon.PanicContinue)
+	ctx.SetHeartbeatTimeout(2 * configs.HeartBeatTime)
+	return ctx
+}
+
+// worker returns the actual daemon loop itself. It runs until ctx is cancelled by the daemon framework, which happens
+// when a SIGTERM is sent to the daemon. The daemon context supplies the command line options the daemon was started
+// with, and appCtx already holds the configuration loaded by the daemon framework.
+func worker(daemonCtx *daemon.Context, appCtx *appcontext.AppContext) daemon.RunFunc {
+	return func(ctx context.Context) error {
+		return run(ctx, daemonCtx.Options(), appCtx)
+	}
+}
+
+// run is the body of the worker.
+func run(ctx context.Context, options daemon.Options, appCtx *appcontext.AppContext) error {
+	// Setup logging
+	logger := log.New()
+	config := appCtx.GetConfig().(*helloworldconfigs.Config)
+	file := logutil.SetupLogging(logger, config.LogFile())
+	defer utils.Close(file, logger)
+	if options.LogLevel != "" {
+		// The level has already been validated by the daemon framework.
+		level, _ := log.ParseLevel(options.LogLevel)
+		logger.SetLevel(level)
+	}
+
+	appCtx.Logger = logger
+	daemon.SetLogger(ctx, logger)
+
+	logger.Info("- - - - - - - - - - - - - - -")
+	logger.Infof("Daemon %v started", helloworldconfigs.AppName)
+
+	logger.WithFields(log.Fields{
+		"app_name":    helloworldconfigs.AppName,
+		"pid_file":    config.PidFile(),
+		"log_file":    config.LogFile(),
+		"working_dir": config.WorkingDir}).Info("Starting daemon")
+	daemon.Ready(ctx)
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	timer := time.Now().UTC()
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("daemon worker() graceful shutdown")
+			return nil
+		case <-ticker.C:
+			// Make sure we don't call the orchestration too quickly.
+			// This also provides a minor pause on daemon start-up.
+			// The configuration is read on every tick, so a reload takes effect straight away.
+			config := appCtx.GetConfig().(*helloworldconfigs.Config)
+			if utils.IsTimeUp(time.Duration(config.OrchestrationWaitTime), timer) {
+				timer = time.Now().UTC()
+				err := daemon.Guard(ctx, func() error {
+					return Daemon(appCtx)
+				})
+				daemon.RecordRun(ctx, timer, err)
+				daemon.RecordHeartbeat(ctx, appCtx.GetHeartBeat())
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package context
 
 import (
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -15,6 +16,15 @@ type AppContext struct {
 	// Heartbeat is a timer used to intermittently log a message to the daemon log indicating that the daemon is
 	// actively processing.
 	Heartbeat time.Time
+
+	// config is the current configuration of the daemon. It is replaced as a whole when the daemon reloads its
+	// configuration, so it is safe to read while a reload is in progress.
+	config atomic.Value
+}
+
+// GetConfig gets the current configuration of the daemon, or nil if it hasn't been set.
+func (ctx *AppContext) GetConfig() interface{} {
+	return ctx.config.Load()
 }
 
 // GetHeartBeat gets the value of the heartbeat timer.
@@ -27,6 +37,11 @@ func (ctx *AppContext) GetLogger() *log.Logger {
 	return ctx.Logger
 }
 
+// SetConfig atomically replaces the configuration of the daemon.
+func (ctx *AppContext) SetConfig(config interface{}) {
+	ctx.config.Store(config)
+}
+
 // SetHeartBeat sets the value of the heartbeat timer based on the value passed in.
 func (ctx *AppContext) SetHeartBeat(t time.Time) {
 	ctx.Heartbeat = t
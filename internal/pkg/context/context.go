@@ -9,10 +9,14 @@ import (
 
 // Context interface exports methods for daemons to implement
 type Context interface {
+	// GetConfig gets the current configuration of the daemon.
+	GetConfig() interface{}
 	// GetHeartBeat gets the heartbeat value stored in context.
 	GetHeartBeat() time.Time
 	// GetLogger returns an instance of logger
 	GetLogger() *log.Logger
+	// SetConfig atomically replaces the configuration of the daemon.
+	SetConfig(config interface{})
 	// SetHeartBeat sets the heartbeat value stored in the context.
 	SetHeartBeat(t time.Time)
 }
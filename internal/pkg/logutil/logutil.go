@@ -14,14 +14,19 @@ import (
 )
 
 // SetupLogging will setup a log file using logName and return a handle to the file. The logName will have the
-// project log path prepended to it so you only need to send just the log name. ex. directord.log
+// project log path prepended to it so you only need to send just the log name. ex. directord.log. An absolute logName,
+// e.g. from the daemon's configuration, is used as is.
 //
 // NOTE: The caller is required to close the returned file when finished with it.
-//  Param logger: in/out, Pointer to a Logger object.
-//  Param logName: in, log name to be used when opening the log file. ex. "name.log"
-//  Returns pointer to a File.
+//
+//	Param logger: in/out, Pointer to a Logger object.
+//	Param logName: in, log name to be used when opening the log file. ex. "name.log"
+//	Returns pointer to a File.
 func SetupLogging(logger *log.Logger, logName string) *os.File {
-	fullLogName := filepath.Join(configs.LogPath, logName)
+	fullLogName := logName
+	if !filepath.IsAbs(logName) {
+		fullLogName = filepath.Join(configs.DefaultLogPath(), logName)
+	}
 	file, err := os.OpenFile(fullLogName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
 	if err != nil {
 		// Cannot open log file.
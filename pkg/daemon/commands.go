@@ -0,0 +1,541 @@
+package daemon
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNoWorker is returned when the daemon is started without a worker or run function.
+var ErrNoWorker = errors.New("no worker function/implementation supplied")
+
+// Command is a command line subcommand of the daemon, "<daemon> [global flags] <name> [flags] [args...]".
+type Command struct {
+	// Name is the name of the subcommand on the command line.
+	Name string
+	// Synopsis is the one line description shown in the usage information.
+	Synopsis string
+	// SetFlags is optional, and registers the subcommand's own flags. The global flags are always available.
+	SetFlags func(flags *flag.FlagSet)
+	// Run executes the subcommand. The flags have already been parsed, and flags.Args() holds the remaining
+	// arguments. Return an *ExitError to control the exit code.
+	Run func(ctx *Context, flags *flag.FlagSet) error
+	// SkipPaths is set for a subcommand that doesn't use the daemon or its files, so the handler set with
+	// SetPathsHandler isn't called first; e.g. a command that checks the configuration has to run when it is broken.
+	SkipPaths bool
+}
+
+// ExitError is returned by a Command to exit with a specific code. Err is printed when it is not nil.
+type ExitError struct {
+	Code ExitCode
+	Err  error
+}
+
+// Error returns the message of the wrapped error.
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %v", e.Code)
+	}
+	return e.Err.Error()
+}
+
+// Options are the global command line flags, accepted before or after the subcommand name.
+type Options struct {
+	// ConfigFile is the value of --config, the daemon's configuration file.
+	ConfigFile string
+	// PidFile is the value of --pidfile, which overrides the PID file passed to New.
+	PidFile string
+	// LogLevel is the value of --log-level, a logrus level name such as "debug" or "info".
+	LogLevel string
+	// Foreground is the value of --foreground, which makes "start" behave like "foreground".
+	Foreground bool
+	// Settings are the values of --set, "key=value" configuration settings that override the configuration file and
+	// the environment.
+	Settings []string
+}
+
+// AddCommand is an optional method used to register an implementor specific subcommand. It is listed in the usage
+// information along with its flags, and runs in the parent process; use AddControlCommand for commands that have
+// to run inside the daemon.
+func (ctx *Context) AddCommand(cmd Command) {
+	ctx.extraCommands = append(ctx.extraCommands, cmd)
+}
+
+// Options returns the global command line flags. In the daemon process these are the flags the parent process was
+// given when it started the daemon.
+func (ctx *Context) Options() Options {
+	return ctx.options
+}
+
+// setGlobalFlags registers the global flags on flags.
+func (ctx *Context) setGlobalFlags(flags *flag.FlagSet) {
+	flags.StringVar(&ctx.options.ConfigFile, "config", ctx.options.ConfigFile, "configuration `file` for the daemon")
+	flags.StringVar(&ctx.options.PidFile, "pidfile", ctx.options.PidFile, "PID `file`, overrides the default")
+	flags.StringVar(&ctx.options.LogLevel, "log-level", ctx.options.LogLevel, "log `level`: debug, info, warn or error")
+	flags.BoolVar(&ctx.options.Foreground, "foreground", ctx.options.Foreground,
+		"start in the foreground, as for the foreground command")
+	flags.Var(settingsFlag{&ctx.options.Settings}, "set", "set a configuration `key=value`, can be repeated")
+}
+
+// newFlagSet returns a flag set named name with the global flags registered.
+func (ctx *Context) newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	ctx.setGlobalFlags(flags)
+	return flags
+}
+
+// applyOptions validates the global flags and applies the ones that change the daemon context.
+func (ctx *Context) applyOptions() error {
+	if ctx.options.LogLevel != "" {
+		if _, err := logrus.ParseLevel(ctx.options.LogLevel); err != nil {
+			return err
+		}
+	}
+	if ctx.options.PidFile != "" {
+		ctx.goctx.PidFileName = ctx.options.PidFile
+	}
+	return nil
+}
+
+// daemonArgs returns the command line for the daemon process: the application name followed by the global flags
+// that have to reach it.
+func (ctx *Context) daemonArgs() []string {
+	return append([]string{ctx.goctx.Args[0]}, ctx.options.args()...)
+}
+
+// args returns the global flags that have to reach the daemon process, as command line arguments.
+func (options Options) args() []string {
+	var args []string
+	if options.ConfigFile != "" {
+		args = append(args, "--config", options.ConfigFile)
+	}
+	if options.PidFile != "" {
+		args = append(args, "--pidfile", options.PidFile)
+	}
+	if options.LogLevel != "" {
+		args = append(args, "--log-level", options.LogLevel)
+	}
+	for _, setting := range options.Settings {
+		args = append(args, "--set", setting)
+	}
+	return args
+}
+
+// findDaemon returns the process of the running daemon, or nil if it isn't running.
+func findDaemon(ctx *Context) *os.Process {
+	proc, _ := locateDaemon(ctx)
+	return proc
+}
+
+// locateDaemon returns the process of the running daemon. ErrNotRunning is returned when there is no PID file, and
+// ErrStalePidFile when the PID file was left behind by a daemon that is gone. The process recorded in the PID file
+// must be the one that wrote it, so a process ID reused by an unrelated process is never signalled; a stale PID file
+// is reported and removed.
+//
+// A daemon running with the system PID file is found first, see Paths.SystemPidFile; the context then uses that PID
+// file, and the control socket next to it.
+func locateDaemon(ctx *Context) (*os.Process, error) {
+	if name := ctx.systemPidFile; name != "" && name != ctx.goctx.PidFileName {
+		if rec, err := readPidRecord(name); err == nil && rec.verify(name) == nil {
+			ctx.goctx.PidFileName = name
+			return os.FindProcess(rec.Pid)
+		}
+	}
+
+	name := ctx.goctx.PidFileName
+	rec, err := readPidRecord(name)
+	if os.IsNotExist(err) {
+		return nil, ErrNotRunning
+	} else if err != nil {
+		return nil, err
+	}
+	if err = rec.verify(name); err != nil {
+		if removeErr := removeStalePidFile(name); removeErr != nil {
+			ctx.printf("Found a stale PID file %v, %v, but cannot remove it, %v\n", name, err, removeErr)
+			return nil, ErrStalePidFile
+		}
+		ctx.printf("Removed the stale PID file %v, %v\n", name, err)
+		return nil, ErrStalePidFile
+	}
+	return os.FindProcess(rec.Pid)
+}
+
+// notRunning returns the error used when a command needs a running daemon and there isn't one.
+func notRunning(ctx *Context) error {
+	return &ExitError{Code: ExitNotRunning, Err: fmt.Errorf("cannot find a daemon running for %v", ctx.goctx.Args[0])}
+}
+
+// builtinCommands returns the subcommands provided by this package, in the order they are listed in the usage
+// information.
+func builtinCommands() []Command {
+	var stopTimeout time.Duration
+	setStopFlags := func(flags *flag.FlagSet) {
+		flags.DurationVar(&stopTimeout, "timeout", 0, "grace `period` for the first stop signal, overrides the policy")
+	}
+	var asJSON, graceful bool
+	var serviceType, serviceUser string
+	var dryRun bool
+	setServiceFlags := func(flags *flag.FlagSet) {
+		flags.StringVar(&serviceType, "type", detectServiceType(), "service `type`, systemd or sysv")
+		flags.BoolVar(&dryRun, "dry-run", false, "print what would be done instead of doing it")
+	}
+
+	return []Command{
+		{
+			Name:     "help",
+			Synopsis: "print this usage information",
+			Run: func(ctx *Context, _ *flag.FlagSet) error {
+				printUsage(ctx)
+				return nil
+			},
+		},
+		{
+			Name:     "start",
+			Synopsis: "start as a daemon",
+			Run: func(ctx *Context, _ *flag.FlagSet) error {
+				return cmdStart(ctx)
+			},
+		},
+		{
+			Name:     "stop",
+			Synopsis: "find the running daemon, and shut it down",
+			SetFlags: setStopFlags,
+			Run: func(ctx *Context, _ *flag.FlagSet) error {
+				return cmdStop(ctx, StopOptions{Timeout: stopTimeout})
+			},
+		},
+		{
+			Name:     "restart",
+			Synopsis: "perform a stop + start operation",
+			SetFlags: func(flags *flag.FlagSet) {
+				setStopFlags(flags)
+				flags.BoolVar(&graceful, "graceful", false,
+					"start the new daemon, passing on its sockets, before stopping the old one")
+			},
+			Run: func(ctx *Context, _ *flag.FlagSet) error {
+				return cmdRestart(ctx, RestartOptions{Timeout: stopTimeout, Graceful: graceful})
+			},
+		},
+		{
+			Name:     "reload",
+			Synopsis: "find the running daemon, and have it reload it's configuration",
+			Run: func(ctx *Context, _ *flag.FlagSet) error {
+				return cmdReload(ctx)
+			},
+		},
+		{
+			Name:     "status",
+			Synopsis: "find the running daemon, and print out it's PID, uptime, last run and resource usage",
+			SetFlags: func(flags *flag.FlagSet) {
+				flags.BoolVar(&asJSON, "json", false, "print the status as JSON")
+			},
+			Run: func(ctx *Context, _ *flag.FlagSet) error {
+				return cmdStatus(ctx, asJSON)
+			},
+		},
+		{
+			Name:     "debug",
+			Synopsis: "start, not as a daemon, but as a foreground process for debugging purposes",
+			Run: func(ctx *Context, _ *flag.FlagSet) error {
+				if proc := findDaemon(ctx); proc != nil {
+					return fmt.Errorf("cannot run in DEBUG mode, the daemon %v is running with PID %v",
+						ctx.goctx.Args[0], proc.Pid)
+				}
+				return debugDaemon(ctx)
+			},
+		},
+		{
+			Name:     "foreground",
+			Synopsis: "run as the daemon, but in the foreground, for service managers such as systemd",
+			Run: func(ctx *Context, _ *flag.FlagSet) error {
+				ctx.options.Foreground = true
+				return cmdStart(ctx)
+			},
+		},
+		{
+			Name:     "install-service",
+			Synopsis: "write a systemd unit or LSB init script for the daemon",
+			SetFlags: func(flags *flag.FlagSet) {
+				setServiceFlags(flags)
+				flags.StringVar(&serviceUser, "user", "", "`user` to run the daemon as")
+			},
+			Run: func(ctx *Context, _ *flag.FlagSet) error {
+				return installService(ctx, serviceType, serviceUser, dryRun)
+			},
+		},
+		{
+			Name:     "uninstall-service",
+			Synopsis: "remove the systemd unit or LSB init script for the daemon",
+			SetFlags: setServiceFlags,
+			Run: func(ctx *Context, _ *flag.FlagSet) error {
+				return uninstallService(ctx, serviceType, dryRun)
+			},
+		},
+	}
+}
+
+// cmdStart implements "start", and "foreground" when Options.Foreground is set. As the LSB asks, starting a daemon
+// that is already running succeeds.
+func cmdStart(ctx *Context) error {
+	if ctx.options.Foreground {
+		return cmdForeground(ctx)
+	}
+
+	proc, err := ctx.Start()
+	switch err {
+	case nil:
+		fmt.Println("Daemon successfully started")
+	case ErrAlreadyRunning:
+		fmt.Printf("There is already a daemon running for %v, with process ID %v\n", ctx.goctx.Args[0], proc.Pid)
+	default:
+		return exitError(err, "cannot start the daemon %v", ctx.goctx.Args[0])
+	}
+	return nil
+}
+
+// cmdForeground implements "foreground", which runs the daemon in this process until it exits.
+func cmdForeground(ctx *Context) error {
+	if proc := findDaemon(ctx); proc != nil {
+		fmt.Printf("There is already a daemon running for %v, with process ID %v\n", ctx.goctx.Args[0], proc.Pid)
+		return nil
+	}
+	if !ctx.hasWorker() {
+		return exitError(ErrNoWorker, "cannot start the daemon %v", ctx.goctx.Args[0])
+	}
+	if err := runForeground(ctx); err != nil {
+		return exitError(err, "the daemon %v failed", ctx.goctx.Args[0])
+	}
+	return nil
+}
+
+// cmdStop implements "stop". The exit code reports which step of the StopPolicy ended the daemon. As the LSB asks,
+// stopping a daemon that isn't running succeeds.
+func cmdStop(ctx *Context, opts StopOptions) error {
+	result, err := ctx.Stop(opts)
+	if err == ErrNotRunning {
+		fmt.Printf("There is no daemon running for %v\n", ctx.goctx.Args[0])
+		return nil
+	} else if err != nil {
+		return exitError(err, "cannot stop the daemon %v", ctx.goctx.Args[0])
+	}
+	fmt.Println(result)
+	if code := result.ExitCode(); code != ExitSuccess {
+		return &ExitError{Code: code}
+	}
+	return nil
+}
+
+// cmdRestart implements "restart". A daemon that isn't running is simply started. The exit code reports how the old
+// daemon was stopped, as for "stop".
+func cmdRestart(ctx *Context, opts RestartOptions) error {
+	result, err := ctx.Restart(opts)
+	if err != nil {
+		if opts.Graceful && result.OldPid != 0 {
+			return exitError(err, "cannot restart the daemon %v gracefully", ctx.goctx.Args[0])
+		}
+		return exitError(err, "cannot restart the daemon %v", ctx.goctx.Args[0])
+	}
+	if result.OldPid == 0 {
+		fmt.Println("Daemon successfully started")
+		return nil
+	}
+	fmt.Println("Daemon successfully restarted")
+	if result.Stop != nil {
+		if code := result.Stop.ExitCode(); code != ExitSuccess {
+			return &ExitError{Code: code}
+		}
+	}
+	return nil
+}
+
+// cmdReload implements "reload", and reports the configuration keys that changed.
+func cmdReload(ctx *Context) error {
+	result, err := ctx.Reload()
+	if err != nil {
+		return exitError(err, "cannot reload the daemon %v", ctx.goctx.Args[0])
+	}
+	switch {
+	case result.Signalled:
+	case len(result.Changed) == 0:
+		fmt.Println("Daemon with PID", result.Pid, "reloaded its configuration, nothing changed")
+	default:
+		fmt.Println("Daemon with PID", result.Pid, "reloaded its configuration, changed:",
+			strings.Join(result.Changed, ", "))
+	}
+	return nil
+}
+
+// cmdStatus implements "status", with the exit codes of an LSB "status" action.
+func cmdStatus(ctx *Context, asJSON bool) error {
+	status, err := ctx.Status()
+	switch err {
+	case nil:
+		return displayStatus(status, asJSON)
+	case ErrNotRunning:
+		return &ExitError{Code: StatusNotRunning, Err: fmt.Errorf("the daemon %v is not running", ctx.goctx.Args[0])}
+	case ErrStalePidFile:
+		return &ExitError{Code: StatusDead, Err: fmt.Errorf("the daemon %v is dead", ctx.goctx.Args[0])}
+	default:
+		return &ExitError{Code: StatusUnknown, Err: fmt.Errorf("cannot read the PID file %v, %v",
+			ctx.goctx.PidFileName, err)}
+	}
+}
+
+// lookupCommand returns the subcommand called name, searching the built-in commands first.
+func (ctx *Context) lookupCommand(name string) (Command, bool) {
+	for _, cmd := range append(builtinCommands(), ctx.extraCommands...) {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return Command{}, false
+}
+
+// runCommandLine is the function used by the parent process to parse the command line arguments (without the program
+// name) and run the subcommand. Returns the exit code for the process.
+func runCommandLine(ctx *Context, args []string) int {
+	if ctx.output == nil {
+		ctx.output = os.Stdout
+	}
+	global := ctx.newFlagSet(ctx.goctx.Args[0])
+	if err := global.Parse(args); err != nil {
+		return usageError(ctx, err)
+	}
+	if global.NArg() == 0 {
+		printUsage(ctx)
+		return int(ExitInvalidArgument)
+	}
+	name := global.Arg(0)
+
+	var err error
+	if cmd, ok := ctx.lookupCommand(name); ok {
+		flags := ctx.newFlagSet(name)
+		if cmd.SetFlags != nil {
+			cmd.SetFlags(flags)
+		}
+		if err = flags.Parse(global.Args()[1:]); err != nil {
+			return usageError(ctx, err)
+		}
+		if err = ctx.applyOptions(); err != nil {
+			return usageError(ctx, err)
+		}
+		if !cmd.SkipPaths {
+			err = ctx.applyPaths()
+		}
+		if err == nil {
+			err = cmd.Run(ctx, flags)
+		}
+	} else if ctx.commands[name] != nil {
+		// Control commands are parsed by the daemon, so their arguments are passed on untouched.
+		if err = ctx.applyOptions(); err != nil {
+			return usageError(ctx, err)
+		}
+		err = ctx.applyPaths()
+		if err == nil && findDaemon(ctx) == nil {
+			err = notRunning(ctx)
+		} else if err == nil {
+			err = runControlCommand(ctx, name, global.Args()[1:])
+		}
+	} else {
+		return usageError(ctx, fmt.Errorf("unknown command %q", name))
+	}
+
+	if err == nil {
+		return int(ExitSuccess)
+	}
+	if exitErr, ok := err.(*ExitError); ok {
+		if exitErr.Err != nil {
+			fmt.Printf("%v: %v\n", ctx.goctx.Args[0], exitErr.Err)
+		}
+		return int(exitErr.Code)
+	}
+	fmt.Printf("%v: %v\n", ctx.goctx.Args[0], err)
+	return int(exitCodeFor(err))
+}
+
+// usageError prints a command line error followed by the usage information, and returns the exit code for it. Asking
+// for help with -h is not an error.
+func usageError(ctx *Context, err error) int {
+	printUsage(ctx)
+	if err == flag.ErrHelp {
+		return int(ExitSuccess)
+	}
+	fmt.Printf("\n%v: %v\n", ctx.goctx.Args[0], err)
+	return int(ExitInvalidArgument)
+}
+
+// printFlags prints the flags registered on flags, other than the global flags, in the usage information.
+func printFlags(flags *flag.FlagSet, skip map[string]bool) {
+	flags.VisitAll(func(f *flag.Flag) {
+		if skip[f.Name] {
+			return
+		}
+		name, usage := flag.UnquoteUsage(f)
+		if name != "" {
+			name = "--" + f.Name + " " + name
+		} else {
+			name = "--" + f.Name
+		}
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0s" {
+			usage += fmt.Sprintf(" (default %v)", f.DefValue)
+		}
+		fmt.Printf("\t    %-24v %v\n", name, usage)
+	})
+}
+
+// printUsage is the function used by the parent process to display the command line usage information, generated
+// from the built-in commands, the commands added with AddCommand and AddControlCommand, and their flags.
+func printUsage(ctx *Context) {
+	appName := ctx.goctx.Args[0]
+	fmt.Printf("%[1]v is a daemon\n\nUsage:\n\n\t%[1]v [global flags] <command> [flags] [args...]\n\n", appName)
+	printCommands(ctx)
+}
+
+// printCommands prints the commands and global flags part of the usage information.
+func printCommands(ctx *Context) {
+	fmt.Printf("The commands are:\n\n")
+	printCommandList(ctx, append(builtinCommands(), ctx.extraCommands...))
+	printControlCommands(ctx)
+
+	fmt.Printf("\nThe global flags are:\n\n")
+	printFlags(ctx.newFlagSet(""), nil)
+}
+
+// printCommandList prints the commands cmds of ctx, and their flags other than the global flags.
+func printCommandList(ctx *Context, cmds []Command) {
+	globals := map[string]bool{}
+	ctx.newFlagSet("").VisitAll(func(f *flag.Flag) {
+		globals[f.Name] = true
+	})
+
+	for _, cmd := range cmds {
+		fmt.Printf("\t%-18v %v\n", cmd.Name, cmd.Synopsis)
+		if cmd.SetFlags != nil {
+			flags := ctx.newFlagSet(cmd.Name)
+			cmd.SetFlags(flags)
+			printFlags(flags, globals)
+		}
+	}
+}
+
+// printControlCommands prints the names of the commands added to ctx with AddControlCommand, if any.
+func printControlCommands(ctx *Context) {
+	if len(ctx.commands) == 0 {
+		return
+	}
+	names := make([]string, 0, len(ctx.commands))
+	for name := range ctx.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("\nThe commands sent to the running daemon over its control socket are:\n\n\t%v\n",
+		strings.Join(names, ", "))
+}
@@ -0,0 +1,192 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ConfigLoader is the function signature for reading and validating the daemon's configuration. Used by
+// SetConfigLoader. It is called once before the worker starts, and again for every "<daemon> reload"; an error keeps
+// the daemon on its current configuration.
+type ConfigLoader func() (config interface{}, err error)
+
+// ConfigChangeFunc is the function signature for the function told about a new configuration. Used by
+// SetConfigLoader. Changed holds the keys whose values differ from the previous configuration, and is nil for the
+// configuration loaded at start-up.
+type ConfigChangeFunc func(config interface{}, changed []string)
+
+// ReloadResult is the result of a "reload" control request, and of Reload.
+type ReloadResult struct {
+	// Changed are the configuration keys that changed, in dotted form.
+	Changed []string `json:"changed"`
+	// Pid is the process ID of the daemon that reloaded. Filled in by Reload.
+	Pid int `json:"-"`
+	// Signalled is true when the control socket couldn't be reached and the daemon was sent a SIGHUP instead, so
+	// nothing is known about the outcome. Filled in by Reload.
+	Signalled bool `json:"-"`
+}
+
+// SetConfigLoader is an optional method used to give the daemon a reloadable configuration. The configuration returned
+// by load is swapped in atomically, and can be read at any time with Config(). onChange, if supplied, is called after
+// every swap; it is the place to hand the configuration over to the app context.
+//
+// NOTE: the keys reported as changed are the dotted paths of the JSON encoding of the configuration, e.g.
+// "timers.orchestration_wait_time".
+func (ctx *Context) SetConfigLoader(load ConfigLoader, onChange ConfigChangeFunc) {
+	ctx.configLoader = load
+	ctx.configChanged = onChange
+}
+
+// Config returns the current configuration of the daemon, as returned by the loader set with SetConfigLoader. Returns
+// nil before the configuration has been loaded.
+func (ctx *Context) Config() interface{} {
+	return ctx.config.Load()
+}
+
+// loadConfig is the function used by the daemon process to load its configuration before the worker starts.
+func (ctx *Context) loadConfig() error {
+	if ctx.configLoader == nil {
+		return nil
+	}
+
+	config, err := ctx.configLoader()
+	if err == nil {
+		err = checkConfig(nil, config)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid configuration, %v", err)
+	}
+	ctx.config.Store(config)
+	if ctx.configChanged != nil {
+		ctx.configChanged(config, nil)
+	}
+	return nil
+}
+
+// reload is the function used by the daemon process to reload its configuration, and then call the handler set by
+// SetReloadHandler(). The keys that changed are returned; when the new configuration is invalid the daemon keeps the
+// current one and the error is returned instead.
+func (ctx *Context) reload() ([]string, error) {
+	if ctx.configLoader == nil && ctx.reloader == nil {
+		return nil, fmt.Errorf("daemon does not support reload functionality")
+	}
+
+	ctx.reloadMu.Lock()
+	defer ctx.reloadMu.Unlock()
+
+	_ = ctx.state.notifier.notify("RELOADING=1")
+	//noinspection GoUnhandledErrorResult
+	defer ctx.state.notifier.notify("READY=1")
+
+	var changed []string
+	if ctx.configLoader != nil {
+		config, err := ctx.configLoader()
+		if err == nil {
+			err = checkConfig(ctx.config.Load(), config)
+		}
+		if err != nil {
+			ctx.state.logReload(nil, err)
+			return nil, fmt.Errorf("invalid configuration, the daemon keeps running on the current one, %v", err)
+		}
+		changed = changedKeys(ctx.config.Load(), config)
+		ctx.config.Store(config)
+		if ctx.configChanged != nil {
+			ctx.configChanged(config, changed)
+		}
+	}
+
+	if ctx.reloader != nil {
+		if err := ctx.reloader(syscall.SIGHUP); err != nil {
+			ctx.state.logReload(changed, err)
+			return changed, err
+		}
+	}
+	ctx.state.logReload(changed, nil)
+	return changed, nil
+}
+
+// checkConfig returns an error if config, returned by the configuration loader, can't replace current, the
+// configuration in use or nil. The configuration is held in an atomic.Value, which only takes values of a single
+// concrete type, and a nil configuration would be handed over to the daemon.
+func checkConfig(current interface{}, config interface{}) error {
+	value := reflect.ValueOf(config)
+	if config == nil || (value.Kind() == reflect.Ptr && value.IsNil()) {
+		return fmt.Errorf("the configuration loader returned no configuration")
+	}
+	if current != nil && reflect.TypeOf(current) != value.Type() {
+		return fmt.Errorf("the configuration loader returned a %T instead of a %T", config, current)
+	}
+	return nil
+}
+
+// logReload writes the outcome of a reload to the logger handed over with SetLogger(), or the standard logger.
+func (state *runState) logReload(changed []string, err error) {
+	msg := "Configuration reloaded, no changes"
+	if err != nil {
+		msg = fmt.Sprintf("Configuration reload failed, %v", err)
+	} else if len(changed) > 0 {
+		msg = fmt.Sprintf("Configuration reloaded, changed %v", strings.Join(changed, ", "))
+	}
+
+	if err != nil {
+		state.logf(logrus.ErrorLevel, "%v", msg)
+	} else {
+		state.logf(logrus.InfoLevel, "%v", msg)
+	}
+}
+
+// changedKeys compares the JSON encodings of two configurations, and returns the sorted dotted paths of the keys
+// that were added, removed or changed.
+func changedKeys(old interface{}, new interface{}) []string {
+	oldKeys, newKeys := map[string]interface{}{}, map[string]interface{}{}
+	flattenConfig("", toJSONValue(old), oldKeys)
+	flattenConfig("", toJSONValue(new), newKeys)
+
+	var changed []string
+	for key, value := range newKeys {
+		if oldValue, ok := oldKeys[key]; !ok || !reflect.DeepEqual(oldValue, value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range oldKeys {
+		if _, ok := newKeys[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// toJSONValue returns config as the generic value decoded from its JSON encoding.
+func toJSONValue(config interface{}) interface{} {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil
+	}
+	var value interface{}
+	_ = json.Unmarshal(data, &value)
+	return value
+}
+
+// flattenConfig adds the leaf values of value to keys, under their dotted paths starting from prefix.
+func flattenConfig(prefix string, value interface{}, keys map[string]interface{}) {
+	object, ok := value.(map[string]interface{})
+	if !ok || len(object) == 0 {
+		if prefix != "" {
+			keys[prefix] = value
+		}
+		return
+	}
+	for key, child := range object {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		flattenConfig(key, child, keys)
+	}
+}
@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testConfig is the configuration of the configuration tests.
+type testConfig struct {
+	Name  string `json:"name"`
+	Level int    `json:"level"`
+}
+
+// TestReloadKeepsConfig checks that a reload keeps the current configuration, rather than panicking or swapping it,
+// when the loader fails or returns a configuration that can't replace it.
+func TestReloadKeepsConfig(t *testing.T) {
+	var nilConfig *testConfig
+	tests := []struct {
+		name   string
+		config interface{}
+		err    error
+	}{
+		{"error", nil, errors.New("broken")},
+		{"nil", nil, nil},
+		{"nil pointer", nilConfig, nil},
+		{"other type", &struct{ Name string }{"other"}, nil},
+		{"value instead of pointer", testConfig{Name: "value"}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			initial := &testConfig{Name: "initial", Level: 1}
+			next, nextErr := interface{}(initial), error(nil)
+			ctx := &Context{}
+			_ = ctx.New("", "", "/", "test")
+			ctx.SetConfigLoader(func() (interface{}, error) {
+				return next, nextErr
+			}, nil)
+			newRunState(ctx, context.Background())
+			if err := ctx.loadConfig(); err != nil {
+				t.Fatal(err)
+			}
+
+			next, nextErr = test.config, test.err
+			if _, err := ctx.reload(); err == nil {
+				t.Errorf("reload succeeded with %#v", test.config)
+			}
+			if got := ctx.Config(); got != initial {
+				t.Errorf("Config() = %#v after a failed reload, want %#v", got, initial)
+			}
+		})
+	}
+}
+
+// TestReloadChangedKeys checks that a reload swaps in the new configuration and reports the keys that changed.
+func TestReloadChangedKeys(t *testing.T) {
+	next := &testConfig{Name: "initial", Level: 1}
+	ctx := &Context{}
+	_ = ctx.New("", "", "/", "test")
+	var changes [][]string
+	ctx.SetConfigLoader(func() (interface{}, error) {
+		return next, nil
+	}, func(_ interface{}, changed []string) {
+		changes = append(changes, changed)
+	})
+	newRunState(ctx, context.Background())
+	if err := ctx.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	next = &testConfig{Name: "initial", Level: 2}
+	changed, err := ctx.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{"level"}) {
+		t.Errorf("reload changed %v, want [level]", changed)
+	}
+	if ctx.Config() != next {
+		t.Errorf("Config() = %#v, want %#v", ctx.Config(), next)
+	}
+	if want := [][]string{nil, {"level"}}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("onChange called with %v, want %v", changes, want)
+	}
+}
+
+// TestLoadConfigRejectsNil checks that the configuration loaded on start-up can't be nil.
+func TestLoadConfigRejectsNil(t *testing.T) {
+	ctx := &Context{}
+	_ = ctx.New("", "", "/", "test")
+	ctx.SetConfigLoader(func() (interface{}, error) {
+		return nil, nil
+	}, nil)
+	if err := ctx.loadConfig(); err == nil {
+		t.Errorf("loadConfig accepted a nil configuration")
+	}
+}
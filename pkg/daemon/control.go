@@ -0,0 +1,228 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// controlTimeout bounds how long either side of the control socket waits on a single request/response exchange.
+const controlTimeout = 10 * time.Second
+
+// ControlFunc is the function signature for a command served over the daemon's control socket. The args are the
+// command line arguments that followed the command name. The returned value is encoded as JSON and sent back to the
+// caller; a non-nil error is sent back instead as the failure reason.
+type ControlFunc func(args []string) (result interface{}, err error)
+
+// ControlRequest is a single request sent by the parent process to the daemon over the control socket.
+type ControlRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// ControlResponse is the daemon's answer to a ControlRequest. When OK is false Error holds the reason for the failure,
+// otherwise Result holds the JSON encoded value returned by the command.
+type ControlResponse struct {
+	OK     bool            `json:"ok"`
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// controlServer is the daemon side of the control socket.
+type controlServer struct {
+	listener net.Listener
+	path     string
+	commands map[string]ControlFunc
+	// handedOver is set while the socket belongs to another daemon process: once it has been passed on to a new
+	// daemon process by a graceful restart, or in that new process until it has taken over. Closing the server
+	// must then leave the socket in place.
+	mu         sync.Mutex
+	handedOver bool
+}
+
+// AddControlCommand is an optional method used to register an implementor specific command that is served over the
+// daemon's control socket. Once registered "<daemon> <name> [args...]" sends the command to the running daemon and
+// prints the result. The built-in "status", "reload", "stop" and "upgrade" commands cannot be replaced.
+func (ctx *Context) AddControlCommand(name string, f ControlFunc) {
+	if ctx.commands == nil {
+		ctx.commands = make(map[string]ControlFunc)
+	}
+	ctx.commands[name] = f
+}
+
+// controlSocketPath returns the pathname of the control socket, which lives next to the PID file. Returns an empty
+// string when the daemon has no PID file.
+func controlSocketPath(ctx *Context) string {
+	pidFile := ctx.goctx.PidFileName
+	if pidFile == "" {
+		return ""
+	}
+	return strings.TrimSuffix(pidFile, filepath.Ext(pidFile)) + ".sock"
+}
+
+// listenControl opens the control socket for the running daemon. A "stop" request is delivered to the signal loop
+// through sigs, exactly as if a SIGTERM had been received.
+func listenControl(ctx *Context, sigs chan<- os.Signal) (*controlServer, error) {
+	path := controlSocketPath(ctx)
+	if path == "" {
+		return nil, fmt.Errorf("no PID file configured")
+	}
+
+	// A daemon that was killed leaves its socket behind, and the PID file lock guarantees no other instance is
+	// using it.
+	_ = os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0660); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	server := newControlServer(ctx, listener, path, sigs)
+	go server.serve()
+	return server, nil
+}
+
+// newControlServer returns the control server for listener, with the built-in and the implementor's commands. The
+// caller starts serving requests.
+func newControlServer(ctx *Context, listener net.Listener, path string, sigs chan<- os.Signal) *controlServer {
+	server := &controlServer{listener: listener, path: path, commands: make(map[string]ControlFunc)}
+	for name, f := range ctx.commands {
+		server.commands[name] = f
+	}
+	server.commands["status"] = func(_ []string) (interface{}, error) {
+		return ctx.status(), nil
+	}
+	server.commands["reload"] = func(_ []string) (interface{}, error) {
+		changed, err := ctx.reload()
+		if err != nil {
+			return nil, err
+		}
+		return ReloadResult{Changed: changed}, nil
+	}
+	server.commands["stop"] = func(_ []string) (interface{}, error) {
+		sigs <- syscall.SIGTERM
+		return nil, nil
+	}
+	server.commands["upgrade"] = func(_ []string) (interface{}, error) {
+		result, err := ctx.gracefulRestart(server)
+		if err != nil {
+			return nil, err
+		}
+		// The new daemon process is ready, so this one drains and exits as if it had been stopped.
+		sigs <- syscall.SIGTERM
+		return result, nil
+	}
+	return server
+}
+
+// serve accepts connections until the listener is closed.
+func (server *controlServer) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.handle(conn)
+	}
+}
+
+// handle reads a single ControlRequest from conn, runs the matching command and writes back its ControlResponse.
+func (server *controlServer) handle(conn net.Conn) {
+	//noinspection GoUnhandledErrorResult
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	var req ControlRequest
+	resp := ControlResponse{}
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		resp.Error = fmt.Sprintf("invalid request, %v", err)
+	} else if f, ok := server.commands[req.Command]; !ok {
+		resp.Error = fmt.Sprintf("unknown command %q", req.Command)
+	} else if result, err := f(req.Args); err != nil {
+		resp.Error = err.Error()
+	} else if resp.Result, err = json.Marshal(result); err != nil {
+		resp.Error = fmt.Sprintf("cannot encode result, %v", err)
+	} else {
+		resp.OK = true
+	}
+
+	// A command such as "upgrade" may take longer than the deadline, which only applies to the request itself.
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Printf("Cannot send control response for %q, %v\n", req.Command, err)
+	}
+}
+
+// Close stops accepting control requests and removes the socket.
+func (server *controlServer) Close() error {
+	err := server.listener.Close()
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !server.handedOver {
+		_ = os.Remove(server.path)
+	}
+	return err
+}
+
+// setHandedOver records whether the socket belongs to another daemon process.
+func (server *controlServer) setHandedOver(handedOver bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.handedOver = handedOver
+}
+
+// callControl is the function used by the parent process to send a command to the running daemon over its control
+// socket. A returned error means the daemon could not be reached, and the caller should fall back to signals; a
+// failure inside the daemon is reported through ControlResponse.Error instead.
+func callControl(ctx *Context, command string, args []string) (ControlResponse, error) {
+	return callControlTimeout(ctx, command, args, controlTimeout)
+}
+
+// callControlTimeout is callControl for a command that may take up to timeout to complete.
+func callControlTimeout(ctx *Context, command string, args []string, timeout time.Duration) (ControlResponse, error) {
+	var resp ControlResponse
+
+	path := controlSocketPath(ctx)
+	if path == "" {
+		return resp, fmt.Errorf("no PID file configured")
+	}
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		return resp, err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if err = json.NewEncoder(conn).Encode(ControlRequest{Command: command, Args: args}); err != nil {
+		return resp, err
+	}
+	err = json.NewDecoder(conn).Decode(&resp)
+	return resp, err
+}
+
+// runControlCommand is the function used by the parent process to run an implementor registered control command and
+// print its result.
+func runControlCommand(ctx *Context, command string, args []string) error {
+	resp, err := callControl(ctx, command, args)
+	if err != nil {
+		return fmt.Errorf("cannot reach the daemon control socket, %v", err)
+	}
+	if !resp.OK {
+		return fmt.Errorf("%v", resp.Error)
+	}
+	if len(resp.Result) > 0 && string(resp.Result) != "null" {
+		fmt.Println(string(resp.Result))
+	}
+	return nil
+}
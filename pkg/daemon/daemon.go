@@ -11,44 +11,67 @@
 package daemon
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	godaemon "github.com/sevlyar/go-daemon"
 )
 
-const usage = `%[1]v is a daemon
-
-Usage:
-
-	%[1]v <command>
-
-The commands are:
-
-	help       Print this usage information
-	start      start as a daemon
-	stop       find the running daemon, and shut it down
-	restart    perform a stop + start operation
-	reload     find the running daemon, and have it reload it's configuration
-	status     find the running daemon, and print out it's PID
-	debug      start, not as a daemon, but as a foreground process for debugging purposes`
-
 // HandlerFunc is the function signature for daemon run-time functions that implement the signal handling for various
-// events. Currently used by SetReloadHandler() and  SetTerminatorHandler().
+// events. Currently used by SetReloadHandler(), SetTerminatorHandler() and AddSignalHandler().
 type HandlerFunc func(sig os.Signal) (err error)
 
 // WorkerFunc is the function signature for the daemon worker function. Used by SetWorkerHandler.
 type WorkerFunc func()
 
+// RunFunc is the function signature for a cancellable daemon worker function. Used by SetRunHandler. The supplied
+// context is cancelled when the daemon is asked to shut down, and the returned error becomes the daemon's exit status.
+type RunFunc func(ctx context.Context) error
+
 // Context is the persistent data structure used to store internal daemon data in between function/method calls.
 type Context struct {
-	goctx      *godaemon.Context
-	reloader   HandlerFunc
-	terminator HandlerFunc
-	worker     func()
+	commands         map[string]ControlFunc
+	config           atomic.Value
+	configChanged    ConfigChangeFunc
+	configLoader     ConfigLoader
+	environment      *Environment
+	extraCommands    []Command
+	foreground       bool
+	goctx            *godaemon.Context
+	handedOver       bool
+	heartbeatTimeout time.Duration
+	hosted           bool
+	logOnStdout      bool
+	options          Options
+	output           io.Writer
+	panicPolicy      PanicPolicy
+	pathsHandler     PathsFunc
+	reloadMu         sync.Mutex
+	reloader         HandlerFunc
+	resources        *Resources
+	runAs            *RunAs
+	runner           RunFunc
+	signalHandlers   map[os.Signal]HandlerFunc
+	startTimeout     time.Duration
+	state            *runState
+	stopPolicy       *StopPolicy
+	supervisor       *SupervisorPolicy
+	systemPidFile    string
+	terminator       HandlerFunc
+	upgrade          *upgradeState
+	upgradeConn      *os.File
+	upgradeMu        sync.Mutex
+	upgrading        bool
+	version          string
+	worker           func()
 }
 
 // New allocates a Context structure using the supplied parameters. Once allocated this context maintains
@@ -76,6 +99,15 @@ func (ctx *Context) SetWorkerHandler(f WorkerFunc) {
 	ctx.worker = f
 }
 
+// SetRunHandler is used to set the implementors cancellable worker function. It is the preferred alternative to
+// SetWorkerHandler. When a "<daemon> stop" is received the context passed to f is cancelled, the framework waits for f
+// to return, and a non-nil error (other than context.Canceled) causes the daemon to exit with a non-zero status.
+// SetTerminatorHandler is not required when using SetRunHandler, but if set it is invoked before the context is
+// cancelled.
+func (ctx *Context) SetRunHandler(f RunFunc) {
+	ctx.runner = f
+}
+
 // SetReloadHandler is an optional method used to set the function called when a "<daemon> reload" CLI operation is
 // called. The implementation of this function is provided by the implementor of the daemon. If not provided
 // "<daemon> reload" is a noop.
@@ -90,259 +122,383 @@ func (ctx *Context) SetTerminatorHandler(f HandlerFunc) {
 	ctx.terminator = f
 }
 
+// hasWorker returns true if the implementor has supplied either a worker function or a cancellable run function.
+func (ctx *Context) hasWorker() bool {
+	return ctx.worker != nil || ctx.runner != nil
+}
+
 // debugDaemon is the function used to run as a foreground process instead of a daemon process. Since there is no
 // underlying daemon implementation running the application will behave like a normal application. Ctrl-C or the
-// debugger stop command is required to terminate the process.
-func debugDaemon(ctx *Context) {
-	// Execute the daemon implementors code, but *DO NOT* execute as a goroutine. Executing as a goroutine will
-	// allow the main thread of execution to continue on, and will result in the application exiting.
-	ctx.worker()
-}
+// debugger stop command is required to terminate the process. When a run function has been supplied Ctrl-C cancels
+// its context, and the error it returns is passed back to the caller.
+func debugDaemon(ctx *Context) error {
+	run := ctx.runFunc()
+	runCtx, cancel := context.WithCancel(newRunState(ctx, context.Background()))
+	defer cancel()
+
+	if err := ctx.makeDirs(); err != nil {
+		return err
+	}
+	if err := ctx.loadConfig(); err != nil {
+		return err
+	}
 
-// reloadDaemonConfig is the function used by the parent process to signal the running daemon to call the
-// handler set by SetReloadHandler().
-func reloadDaemonConfig(ctx *Context, proc *os.Process) error {
-	if ctx.reloader != nil {
-		fmt.Println("Sending reload signal to daemon with PID,", proc.Pid)
-		_ = proc.Signal(syscall.SIGHUP)
-	} else {
-		fmt.Println("Daemon does not support reload functionality.")
+	if ctx.runner == nil {
+		// Execute the daemon implementors code, but *DO NOT* execute as a goroutine. Executing as a goroutine will
+		// allow the main thread of execution to continue on, and will result in the application exiting.
+		return runResult(run(runCtx))
 	}
-	return nil
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(ch)
+	go func() {
+		select {
+		case <-ch:
+			cancel()
+		case <-runCtx.Done():
+		}
+	}()
+
+	return runResult(run(runCtx))
 }
 
-// displayStatus is the function used by the parent process to display information about the running daemon.
-func displayStatus(ctx *Context, proc *os.Process) {
-	fmt.Printf("%v running as a daemon with PID %v\n", ctx.goctx.Args[0], proc.Pid)
+// runFunc returns the function executed as the daemon's worker. The worker is always protected against panics, and is
+// wrapped so that it is restarted after a crash when either the supervisor or the panic policy asks for it. A worker
+// set with SetWorkerHandler is run as a run function that ignores its context.
+func (ctx *Context) runFunc() RunFunc {
+	run := ctx.runner
+	if run == nil {
+		run = func(_ context.Context) error {
+			ctx.worker()
+			return nil
+		}
+	}
+
+	protected := func(runCtx context.Context) error {
+		err := ctx.protect(runCtx, run)
+		if err != nil && err != context.Canceled {
+			// A worker that fails before it is ready fails the start-up, even when it is restarted.
+			ctx.state.startupFailed(err)
+		}
+		return err
+	}
+	if ctx.supervisor == nil && ctx.getPanicPolicy() == PanicExit {
+		return protected
+	}
+	return func(runCtx context.Context) error {
+		return ctx.supervise(runCtx, protected)
+	}
 }
 
-// printUsage is the function used by the parent process to display the command line usage information.
-func printUsage(ctx *Context) {
-	fmt.Printf(usage, ctx.goctx.Args[0])
+// runResult maps the error returned by a run function onto the daemon's result. A run function that returns because
+// its context was cancelled has shut down cleanly.
+func runResult(err error) error {
+	if err == context.Canceled {
+		return nil
+	}
+	return err
 }
 
 // runDaemon is the function used by the daemon process to register the reload and terminator handlers, and then
 // execute the daemon implementors specific daemon processing code. The daemon implementors worker function is
-// executed as a goroutine, and this function will block on the underlying ServeSignals() function; this is what
-// allows the daemon to receive the SIGTERM and SIGHUP signals from the parent process.
-func runDaemon(ctx *Context) {
-	if ctx.terminator != nil {
-		godaemon.SetSigHandler(godaemon.SignalHandlerFunc(ctx.terminator), syscall.SIGTERM)
+// executed as a goroutine, and this function will block on serveSignals(); this is what allows the daemon to receive
+// the SIGTERM and SIGHUP signals from the parent process. When a run function has been supplied this function also
+// returns once it finishes, and passes back its error.
+func runDaemon(ctx *Context) error {
+	runCtx, cancel := context.WithCancel(newRunState(ctx, context.Background()))
+	defer cancel()
+
+	log.Printf("Daemon %v starting with PID %v, environment: %v\n", ctx.goctx.Args[0], os.Getpid(),
+		ctx.maskedEnv(os.Environ()))
+
+	// Open the control socket. The daemon still works without it, the parent process falls back to signals. A daemon
+	// process started by a graceful restart inherits the socket, and only serves it once the old process has exited.
+
+	var err error
+	var server *controlServer
+	sigs := make(chan os.Signal, 8)
+	if ctx.upgrade != nil {
+		server = newControlServer(ctx, ctx.upgrade.control, controlSocketPath(ctx), sigs)
+		server.setHandedOver(true)
+	} else if server, err = listenControl(ctx, sigs); err != nil {
+		log.Printf("Daemon %v cannot open its control socket, %v\n", ctx.goctx.Args[0], err)
 	}
-	if ctx.reloader != nil {
-		godaemon.SetSigHandler(godaemon.SignalHandlerFunc(ctx.reloader), syscall.SIGHUP)
+	if server != nil {
+		//noinspection GoUnhandledErrorResult
+		defer server.Close()
 	}
 
-	// Execute the daemon implementors code as a goroutine.
+	// Record the identity of this process in the PID file, so the parent process can tell it apart from a process that
+	// reuses its process ID later on. A daemon process started by a graceful restart does this when it takes over.
 
-	go ctx.worker()
+	if ctx.upgrade != nil {
+		go ctx.takeOver(server)
+	} else if err = writePidRecord(ctx.goctx.PidFileName); err != nil {
+		log.Printf("Daemon %v cannot record its identity in the PID file, %v\n", ctx.goctx.Args[0], err)
+	}
 
-	// Block here and process any incoming signals from the parent process. Currently only SIGTERM and SIGHUP
-	// are supported.
+	// The parent process waits for the worker to be ready, or for the reason it failed. Its socket is connected to
+	// while this process can still reach it.
 
-	err := godaemon.ServeSignals()
-	if err != nil {
-		log.Printf("Daemon %v failed with error, %v\n", ctx.goctx.Args[0], err)
-		return
+	ctx.state.startup = dialStartup()
+
+	// Apply the resource limits and scheduling settings while still root, as raising them needs the privileges.
+
+	if err = applyResources(ctx); err != nil {
+		err = fmt.Errorf("cannot apply the resource settings, %v", err)
+		ctx.state.startupFailed(err)
+		return err
 	}
-	log.Printf("Daemon %v terminated normally", ctx.goctx.Args[0])
-}
 
-// startDaemon is the function used by the parent process to start up a daemon. Note, the parent and the daemon are
-// the same exact go binary, they just execute a different path depending on which one they are.
-func startDaemon(ctx *Context) error {
-	_, err := ctx.goctx.Reborn()
-	return err
-}
+	// Load the configuration, so the worker starts with a valid one. Reloads replace it while the daemon runs. It is
+	// loaded before the privileges are dropped, with the identity the parent process resolved the paths with.
 
-// stopDaemon is the function used by the parent process to signal a running daemon process to perform a shutdown.
-// This function will monitor the running daemon and if it doesn't shut down in a timely manner first try sending a
-// SIGINT, and if that doesn't work send a SIGKILL.
-func stopDaemon(_ *Context, proc *os.Process) error {
-	fmt.Printf("Shutting down daemon with PID, %v", proc.Pid)
-	_ = proc.Signal(syscall.SIGTERM)
-
-	// Monitor the process for 10seconds, waiting for it to self-terminate.
-
-	terminated := false
-	monitorStart := time.Now().UTC()
-	for time.Now().UTC().Before(monitorStart.Add(10 * time.Second)) {
-		fmt.Printf(".")
-		time.Sleep(1 * time.Second)
-		err := proc.Signal(syscall.Signal(0))
-		if err != nil {
-			terminated = true
-			break
-		}
+	if err = ctx.loadConfig(); err != nil {
+		ctx.state.startupFailed(err)
+		return err
 	}
-	fmt.Println("")
 
-	// If the process never self terminates, then we'll try a SIGINT.
+	// Give up root before any of the daemon implementors code runs. The files created so far, and their directories,
+	// are handed over to the new identity, so they can still be written and removed.
 
-	if !terminated {
-		fmt.Printf("Sending a SIGINT to the daemon with PID, %v", proc.Pid)
-		_ = proc.Signal(syscall.SIGINT)
+	if err = dropPrivileges(ctx, ctx.goctx.PidFileName, ctx.goctx.LogFileName, controlSocketPath(ctx)); err != nil {
+		err = fmt.Errorf("cannot drop privileges, %v", err)
+		ctx.state.startupFailed(err)
+		return err
+	}
 
-		// Monitor the process for 10seconds, waiting for the SIGINT to take effect.
+	// Service manager notifications are sent over $NOTIFY_SOCKET, when it is set.
 
-		terminated = false
-		monitorStart = time.Now().UTC()
-		for time.Now().UTC().Before(monitorStart.Add(10 * time.Second)) {
-			fmt.Printf(".")
-			time.Sleep(1 * time.Second)
-			err := proc.Signal(syscall.Signal(0))
-			if err != nil {
-				terminated = true
-				break
-			}
+	ctx.state.notifier = newNotifier()
+
+	// Catch the signals before the worker starts, so a SIGTERM sent as soon as the worker is ready stops it, rather
+	// than killing the daemon process. SIGTERM and SIGHUP are always handled, along with the built-in and registered
+	// handlers for any other signal.
+
+	done := make(chan struct{})
+	handlers := ctx.signalHandlerMap()
+	handlers[syscall.SIGTERM] = func(sig os.Signal) error {
+		return ctx.terminate(sig, cancel, done)
+	}
+	if ctx.reloader != nil || ctx.configLoader != nil {
+		// The outcome of a SIGHUP reload can only be logged; an invalid configuration doesn't stop the daemon.
+		handlers[syscall.SIGHUP] = func(_ os.Signal) error {
+			_, _ = ctx.reload()
+			return nil
+		}
+	}
+	notifySignals(handlers, sigs)
+	defer signal.Stop(sigs)
+
+	// Execute the daemon implementors code as a goroutine. The done channel is closed when it finishes, except for a
+	// legacy worker that returned normally; that daemon keeps running until it is terminated, as it always has.
+
+	// NOTE: a legacy worker may still be running when SIGTERM stops the daemon, so its error is guarded by runMu.
+
+	var runMu sync.Mutex
+	var runErr error
+	run := ctx.runFunc()
+	go func() {
+		err := runResult(run(runCtx))
+		runMu.Lock()
+		runErr = err
+		runMu.Unlock()
+		if ctx.runner == nil && err == nil {
+			return
 		}
-		fmt.Println("")
+		close(done)
+	}()
 
-		// If the SIGINT doesn't work, send a SIGKILL.
+	// A legacy worker has no way to report that it is ready, so it is ready as soon as it has been started.
 
-		if !terminated {
-			fmt.Println("Sending a SIGKILL to the daemon with PID,", proc.Pid)
-			_ = proc.Signal(syscall.SIGKILL)
+	if ctx.runner == nil {
+		ctx.state.ready()
+	}
+	if interval := watchdogInterval(); interval > 0 {
+		timeout := ctx.heartbeatTimeout
+		if timeout == 0 {
+			timeout = DefaultHeartbeatTimeout
 		}
+		go ctx.state.watchdog(runCtx, interval, timeout)
 	}
 
+	// Block here and process any incoming signals from the parent process.
+
+	err = serveSignals(handlers, sigs, done)
+	if err != nil {
+		log.Printf("Daemon %v failed with error, %v\n", ctx.goctx.Args[0], err)
+		return err
+	}
+	runMu.Lock()
+	err = runErr
+	runMu.Unlock()
+	if err != nil {
+		log.Printf("Daemon %v worker failed with error, %v\n", ctx.goctx.Args[0], err)
+		return err
+	}
+	log.Printf("Daemon %v terminated normally", ctx.goctx.Args[0])
 	return nil
 }
 
-// restartDaemon is the function used by the parent process to stop and (re)start a running daemon.
-func restartDaemon(ctx *Context, proc *os.Process) error {
-	_ = stopDaemon(ctx, proc)
+// terminate is the SIGTERM handler installed by runDaemon. The implementors terminator, if any, is called first and
+// keeps its go-daemon semantics: returning nil keeps the daemon running. When a run function has been supplied its
+// context is then cancelled, and this function blocks until the run function has returned.
+func (ctx *Context) terminate(sig os.Signal, cancel context.CancelFunc, done <-chan struct{}) error {
+	_ = ctx.state.notifier.notify("STOPPING=1")
+
+	err := godaemon.ErrStop
+	if ctx.terminator != nil {
+		err = ctx.terminator(sig)
+	}
+	if ctx.runner == nil || (err != nil && err != godaemon.ErrStop) {
+		return err
+	}
+
+	cancel()
+	<-done
+	return godaemon.ErrStop
+}
+
+// notifySignals relays the signals of handlers to ch, where serveSignals picks them up.
+func notifySignals(handlers map[os.Signal]HandlerFunc, ch chan os.Signal) {
+	signals := make([]os.Signal, 0, len(handlers))
+	for sig := range handlers {
+		signals = append(signals, sig)
+	}
+	signal.Notify(ch, signals...)
+}
 
-	// Actively monitor the old daemon process to determine when it really goes away.
+// serveSignals calls the handler registered for each signal arriving on ch until a handler returns an error, or the
+// done channel is closed. A handler returning godaemon.ErrStop is treated as a normal shutdown. Besides the OS
+// signals, the control socket delivers requests on ch. The signals are relayed to ch by notifySignals.
+func serveSignals(handlers map[os.Signal]HandlerFunc, ch chan os.Signal, done <-chan struct{}) error {
 	for {
-		time.Sleep(1 * time.Second)
-		err := proc.Signal(syscall.Signal(0))
-		if err != nil {
-			break
+		select {
+		case sig := <-ch:
+			err := handlers[sig](sig)
+			if err == godaemon.ErrStop {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		case <-done:
+			return nil
 		}
 	}
+}
+
+// startDaemon is the function used by the parent process to start up a daemon. Note, the parent and the daemon are
+// the same exact go binary, they just execute a different path depending on which one they are. The daemon has
+// started once its worker is ready; if it fails first, or isn't ready within the start timeout, it is stopped and
+// the reason is returned.
+func startDaemon(ctx *Context) (*os.Process, error) {
+	env, err := ctx.daemonEnv(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	if err = ctx.makeDirs(); err != nil {
+		return nil, err
+	}
+	startup, err := listenStartup()
+	if err != nil {
+		return nil, fmt.Errorf("cannot open the start-up socket, %v", err)
+	}
+	//noinspection GoUnhandledErrorResult
+	defer startup.Close()
 
-	return startDaemon(ctx)
+	// The global flags are passed on to the daemon, which parses them again in ProcessCommandLine.
+	ctx.goctx.Args = ctx.daemonArgs()
+	ctx.goctx.Env = startup.env(env)
+	proc, err := ctx.goctx.Reborn()
+	if err != nil {
+		return nil, err
+	}
+
+	// The daemon is a child of this process. When this process outlives it, as tooling calling Start and Stop does,
+	// it has to be reaped, otherwise it stays a zombie that looks like a running daemon. The channel receives the exit
+	// status, and is then closed.
+	exited := make(chan string, 1)
+	go func() {
+		if state, err := proc.Wait(); err != nil {
+			exited <- err.Error()
+		} else {
+			exited <- state.String()
+		}
+		close(exited)
+	}()
+
+	if err = startup.wait(ctx.getStartTimeout(), exited); err != nil {
+		// A daemon that reported its failure normally exits by itself, and releases its PID file on the way out.
+		select {
+		case <-exited:
+		case <-time.After(exitReportWait):
+			ctx.printf("Daemon with PID %v failed to start, stopping it\n", proc.Pid)
+			_, _ = stopDaemon(ctx, proc, ctx.getStopPolicy())
+		}
+		return nil, err
+	}
+	return proc, nil
 }
 
 // ProcessCommandLine is a dual-purpose function. When the daemon is starting up calling ProcessCommandLine will
 // setup the daemons run-time environment then call the implementors worker function to commence the actual daemon
 // processing. The other purpose is to act as the parent process to manage daeomon operations like start, stop,
-// restart, reload and status.
+// restart, reload and status; the commands are a thin layer over Start, Stop, Restart, Reload and Status, which
+// print the results and map the errors onto exit codes.
 //noinspection GoUnusedExportedFunction
 func ProcessCommandLine(ctx *Context) {
-	if godaemon.WasReborn() {
-		// Daemon processing. No exit codes are returned.
+	if godaemon.WasReborn() || upgrading() {
+		// Daemon processing. No exit codes are returned. The global flags given to the parent process are passed on
+		// as the daemon's arguments.
 
-		proc, err := ctx.goctx.Reborn()
-		if err != nil {
-			log.Fatal("Fatal error during daemon start-up, aborting")
+		err := ctx.newFlagSet(ctx.goctx.Args[0]).Parse(os.Args[1:])
+		if err == nil {
+			err = ctx.applyOptions()
 		}
-		if proc == nil {
-			// This is the daemon processing starting up. We need to run the code for normal daemon processing.
-			//noinspection GoUnhandledErrorResult
-			defer ctx.goctx.Release()
-			runDaemon(ctx)
+		if err == nil {
+			err = ctx.applyPaths()
+		}
+		if err != nil {
+			log.Fatalf("Invalid daemon arguments %v, %v", os.Args[1:], err)
 		}
-	} else {
-		// Command line application processing. This will *always* send an OS exit code back to the caller, for easier
-		// bash shell script integration.
 
-		retCode := 0
-		defer func() { os.Exit(retCode) }()
+		// The stdout and stderr of the daemon process are the log file, go-daemon's or the one the process started by a
+		// graceful restart inherits, and are reopened along with it.
 
-		proc, err := ctx.goctx.Search()
-		if err == nil && proc != nil {
-			// In *nix need an additional test to see if the process actually exists...
-			err = proc.Signal(syscall.Signal(0))
-			if err != nil {
-				proc = nil
+		ctx.logOnStdout = ctx.goctx.LogFileName != ""
+		if upgrading() {
+			// A daemon process started by a graceful restart is already detached from the terminal.
+			if err = runUpgraded(ctx); err != nil {
+				log.Printf("Daemon %v failed after a graceful restart, %v\n", ctx.goctx.Args[0], err)
+				os.Exit(1)
 			}
-		}
-
-		if len(os.Args) != 2 {
-			printUsage(ctx)
-			retCode = 1
 			return
 		}
 
-		switch os.Args[1] {
-		case "help":
-			printUsage(ctx)
-		case "start":
-			if err != nil && proc == nil {
-				if ctx.worker != nil {
-					err = startDaemon(ctx)
-					if err == nil {
-						fmt.Println("Daemon successfully started")
-						return
-					}
-					fmt.Printf("Cannot start the daemon %v, err = %v\n", os.Args[0], err)
-				} else {
-					fmt.Println("Cannot start daemon, no worker function/implementation supplied")
-				}
-			} else if proc != nil {
-				fmt.Printf("There is already a daemon running for %v, with process ID %v\n", os.Args[0], proc.Pid)
-			} else {
-				fmt.Println("Should never make it here.")
-			}
-		case "stop":
-			if err == nil && proc != nil {
-				err = stopDaemon(ctx, proc)
-				if err == nil {
-					return
-				}
-				fmt.Printf("Cannot stop the daemon %v, err = %v\n", os.Args[0], err)
-			} else {
-				fmt.Println("Cannot find a daemon running for", os.Args[0])
-			}
-		case "restart":
-			if err == nil && proc != nil {
-				err = restartDaemon(ctx, proc)
-				if err == nil {
-					fmt.Println("Daemon successfully restarted")
-					return
-				}
-				fmt.Printf("Cannot restart the daemon %v, err = %v\n", os.Args[0], err)
-			} else {
-				// Daemon is not currently running, just process as a start.
-				err = startDaemon(ctx)
-				if err == nil {
-					fmt.Println("Daemon successfully started")
-					return
-				}
-				fmt.Printf("Cannot start the daemon %v, err = %v\n", os.Args[0], err)
-			}
-		case "reload":
-			if err == nil && proc != nil {
-				err = reloadDaemonConfig(ctx, proc)
-				if err == nil {
-					return
-				}
-				fmt.Printf("Cannot reload configs for daemon %v, err = %v\n", os.Args[0], err)
-			} else {
-				fmt.Println("Cannot find a daemon running for", os.Args[0])
-			}
-		case "status":
-			if err != nil || proc == nil {
-				fmt.Println("Cannot find a daemon running for", os.Args[0])
-			} else {
-				displayStatus(ctx, proc)
-				return
+		proc, err := ctx.goctx.Reborn()
+		if err != nil {
+			log.Fatal("Fatal error during daemon start-up, aborting")
+		}
+		if proc == nil {
+			// This is the daemon processing starting up. We need to run the code for normal daemon processing. The
+			// worker's result is the daemon's exit status, so the PID file has to be released before exiting.
+			err = runDaemon(ctx)
+			ctx.upgradeMu.Lock()
+			handedOver := ctx.handedOver
+			ctx.upgradeMu.Unlock()
+			if !handedOver {
+				_ = ctx.goctx.Release()
 			}
-		case "debug":
-			if err == nil && proc != nil {
-				fmt.Printf("Cannot run in DEBUG mode, the daemon %v is running with PID %v\n", os.Args[0], proc.Pid)
-			} else {
-				debugDaemon(ctx)
+			if err != nil {
+				os.Exit(1)
 			}
-		default:
-			printUsage(ctx)
 		}
+	} else {
+		// Command line application processing. This will *always* send an OS exit code back to the caller, for easier
+		// bash shell script integration.
 
-		// Getting here indicates that a problem occurred above, so make sure the return code is set properly.
-		retCode = 1
+		os.Exit(runCommandLine(ctx, os.Args[1:]))
 	}
-
-	return
 }
@@ -0,0 +1,104 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// The test binary is re-executed as the daemon process of a test daemon. testDaemonEnv names the directory of its
+// files, testRunAsEnv the user it runs as, if any, and testModeEnv how its run function behaves, see testRunFunc.
+const (
+	testDaemonEnv = "DAEMON_TEST_DIR"
+	testRunAsEnv  = "DAEMON_TEST_RUN_AS"
+	testModeEnv   = "DAEMON_TEST_MODE"
+)
+
+// errTestStartup and errTestStop are the errors the run function of the test daemon fails with in the "fail" and
+// "stop-error" modes.
+var (
+	errTestStartup = errors.New("cannot connect to the test database")
+	errTestStop    = errors.New("cannot flush the test queue")
+)
+
+// TestMain runs the test daemon when the test binary has been started as its daemon process, and the tests otherwise.
+func TestMain(m *testing.M) {
+	if dir := os.Getenv(testDaemonEnv); dir != "" {
+		ProcessCommandLine(newTestDaemon(dir, os.Getenv(testRunAsEnv)))
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestDaemon returns the context of a test daemon with its PID file in dir/run and its log file in dir/log. Its
+// run function is the one of the mode in $DAEMON_TEST_MODE, and a reload does nothing. In the "handler" mode SIGUSR2
+// creates the file dir/SIGUSR2 in place of the built-in handler.
+func newTestDaemon(dir string, runAs string) *Context {
+	ctx := &Context{}
+	_ = ctx.New(filepath.Join(dir, "run", "test.pid"), filepath.Join(dir, "log", "test.log"), "/", "test")
+	if runAs != "" {
+		ctx.SetRunAs(RunAs{User: runAs})
+	}
+	ctx.SetStartTimeout(10 * time.Second)
+	ctx.SetReloadHandler(func(_ os.Signal) error {
+		return nil
+	})
+	if os.Getenv(testModeEnv) == "handler" {
+		ctx.AddSignalHandler(syscall.SIGUSR2, func(sig os.Signal) error {
+			return ioutil.WriteFile(filepath.Join(dir, unix.SignalName(sig.(syscall.Signal))), nil, 0644)
+		})
+	}
+	ctx.SetRunHandler(testRunFunc(dir, os.Getenv(testModeEnv)))
+	return ctx
+}
+
+// testRunFunc returns the run function of the test daemon in mode, with its files in dir. By default it is ready
+// straight away, and returns once it is stopped. In the "fail" mode it fails with errTestStartup before it is ready,
+// in the "exit" mode the process exits with status 3 before it is ready, and in the "hang" mode it is never ready.
+// Once stopped, it fails with errTestStop in the "stop-error" mode, and in the "slow-stop" mode it creates the file
+// dir/cancelled, and dir/returned half a second later, just before it returns.
+func testRunFunc(dir string, mode string) RunFunc {
+	return func(runCtx context.Context) error {
+		switch mode {
+		case "fail":
+			return errTestStartup
+		case "exit":
+			os.Exit(3)
+		case "hang":
+		default:
+			Ready(runCtx)
+		}
+		<-runCtx.Done()
+		switch mode {
+		case "stop-error":
+			return errTestStop
+		case "slow-stop":
+			_ = ioutil.WriteFile(filepath.Join(dir, "cancelled"), nil, 0644)
+			time.Sleep(500 * time.Millisecond)
+			return ioutil.WriteFile(filepath.Join(dir, "returned"), nil, 0644)
+		}
+		return nil
+	}
+}
+
+// startTestDaemon starts a test daemon with its files in dir, and returns its context. The daemon is stopped when the
+// test ends.
+func startTestDaemon(t *testing.T, dir string, runAs string) *Context {
+	t.Setenv(testDaemonEnv, dir)
+	t.Setenv(testRunAsEnv, runAs)
+	ctx := newTestDaemon(dir, runAs)
+	if _, err := ctx.Start(); err != nil {
+		t.Fatalf("cannot start the test daemon, %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = ctx.Stop(StopOptions{})
+	})
+	return ctx
+}
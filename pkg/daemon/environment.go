@@ -0,0 +1,161 @@
+package daemon
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	godaemon "github.com/sevlyar/go-daemon"
+)
+
+// DefaultAllowedEnv are the inherited environment variables passed on to the daemon process when
+// Environment.Allow is empty. The XDG directories are among them, as a daemon run by a user finds its files there.
+var DefaultAllowedEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "LANG", "LC_*", "TZ", "XDG_RUNTIME_DIR",
+	"XDG_STATE_HOME"}
+
+// DefaultSecretEnv are the environment variables whose values are masked in the log when Environment.Secrets is
+// empty.
+var DefaultSecretEnv = []string{"*PASSWORD*", "*PASSWD*", "*SECRET*", "*TOKEN*", "*KEY*", "*CREDENTIAL*"}
+
+// maskedValue replaces the value of a secret in the log.
+const maskedValue = "********"
+
+// Environment controls the environment the daemon process is started with, so it doesn't depend on the shell of
+// whoever ran "<daemon> start". The variables of the environment file are added to the inherited variables named by
+// the allowlist, and nothing else is passed on.
+type Environment struct {
+	// File is an environment file of KEY=VALUE lines, read when the daemon is started. Blank lines and lines starting
+	// with '#' are skipped, "export " before a name is allowed, and a value may be quoted. As for systemd's
+	// EnvironmentFile=, a leading '-' makes the file optional.
+	File string
+	// Allow are the names of the inherited variables passed on to the daemon; a name may be a path.Match pattern such
+	// as "LC_*". If empty DefaultAllowedEnv is used.
+	Allow []string
+	// Secrets are the names, or path.Match patterns, of the variables whose values are masked in the log. If empty
+	// DefaultSecretEnv is used.
+	Secrets []string
+}
+
+// SetEnvironment is an optional method used to control the environment of the daemon process. Without it the daemon
+// inherits the whole environment of the process that started it. The effective environment is logged, with secrets
+// masked, when the daemon starts.
+//
+// NOTE: the environment is applied by "<daemon> start" and "<daemon> restart". A graceful restart keeps the
+// environment of the running daemon, and "foreground" runs with the environment the service manager gives it; the
+// systemd unit written by "install-service" names the file with EnvironmentFile=. "debug" runs in the caller's
+// environment.
+func (ctx *Context) SetEnvironment(env Environment) {
+	ctx.environment = &env
+}
+
+// matchesEnv returns true if the variable name matches one of patterns.
+func matchesEnv(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// internalEnv returns true for the variables this package and go-daemon pass to the daemon process for themselves.
+func internalEnv(name string) bool {
+	return name == godaemon.MARK_NAME || name == startupEnv || name == upgradeEnv
+}
+
+// readEnvFile reads the KEY=VALUE lines of the environment file name.
+func readEnvFile(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer file.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%v:%v: expected KEY=VALUE", name, n)
+		}
+		value := strings.TrimSpace(parts[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env = append(env, key+"="+value)
+	}
+	return env, scanner.Err()
+}
+
+// daemonEnv returns the environment for the daemon process, built from base as set by SetEnvironment. Without
+// SetEnvironment base is returned unchanged. The variables of the environment file override the inherited ones.
+func (ctx *Context) daemonEnv(base []string) ([]string, error) {
+	if ctx.environment == nil {
+		return base, nil
+	}
+	allow := ctx.environment.Allow
+	if len(allow) == 0 {
+		allow = DefaultAllowedEnv
+	}
+
+	values := make(map[string]string)
+	for _, kv := range base {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 && matchesEnv(parts[0], allow) {
+			values[parts[0]] = parts[1]
+		}
+	}
+
+	if name := ctx.environment.File; name != "" {
+		optional := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		fileEnv, err := readEnvFile(name)
+		if err != nil && !(optional && os.IsNotExist(err)) {
+			return nil, fmt.Errorf("cannot read the environment file, %v", err)
+		}
+		for _, kv := range fileEnv {
+			parts := strings.SplitN(kv, "=", 2)
+			values[parts[0]] = parts[1]
+		}
+	}
+
+	env := make([]string, 0, len(values))
+	for key, value := range values {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// maskedEnv returns env as a space separated list of KEY=VALUE, sorted by name, with the values of the secrets
+// replaced. Internal variables of this package and go-daemon are left out.
+func (ctx *Context) maskedEnv(env []string) string {
+	secrets := DefaultSecretEnv
+	if ctx.environment != nil && len(ctx.environment.Secrets) > 0 {
+		secrets = ctx.environment.Secrets
+	}
+
+	var list []string
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || internalEnv(parts[0]) {
+			continue
+		}
+		if matchesEnv(parts[0], secrets) || matchesEnv(strings.ToUpper(parts[0]), secrets) {
+			parts[1] = maskedValue
+		}
+		list = append(list, parts[0]+"="+parts[1])
+	}
+	sort.Strings(list)
+	return strings.Join(list, " ")
+}
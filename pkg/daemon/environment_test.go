@@ -0,0 +1,161 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	godaemon "github.com/sevlyar/go-daemon"
+)
+
+// TestReadEnvFile checks the lines accepted in an environment file, and the values read from them.
+func TestReadEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		err     string
+	}{
+		{"plain", "A=1\nB=two\n", []string{"A=1", "B=two"}, ""},
+		{"blank lines and comments", "\n# a comment\n  # indented\nA=1\n\n", []string{"A=1"}, ""},
+		{"export", "export A=1\n", []string{"A=1"}, ""},
+		{"spaces", "  A = 1  \n", []string{"A=1"}, ""},
+		{"empty value", "A=\n", []string{"A="}, ""},
+		{"double quotes", `A="a b"` + "\n", []string{"A=a b"}, ""},
+		{"single quotes", `A='a "b"'` + "\n", []string{`A=a "b"`}, ""},
+		{"unmatched quotes", `A="a b'` + "\n", []string{`A="a b'`}, ""},
+		{"single quote character", `A="` + "\n", []string{`A="`}, ""},
+		{"equals in value", "A=b=c\n", []string{"A=b=c"}, ""},
+		{"no equals", "A=1\nB\n", nil, ":2: expected KEY=VALUE"},
+		{"no key", "=1\n", nil, ":1: expected KEY=VALUE"},
+		{"space in key", "A B=1\n", nil, ":1: expected KEY=VALUE"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "env")
+			if err := ioutil.WriteFile(name, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			env, err := readEnvFile(name)
+			if test.err != "" {
+				if err == nil || err.Error() != name+test.err {
+					t.Errorf("readEnvFile() = %v, want the error %v%v", err, name, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(env, test.want) {
+				t.Errorf("readEnvFile() = %q, want %q", env, test.want)
+			}
+		})
+	}
+}
+
+// TestDaemonEnv checks that only the allowed inherited variables are passed on to the daemon, and that the variables
+// of the environment file override them.
+func TestDaemonEnv(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "env")
+	if err := ioutil.WriteFile(file, []byte("HOME=/srv/app\nAPP_TOKEN=abc\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+	base := []string{"PATH=/bin", "HOME=/root", "LC_ALL=C", "LC_TIME=C", "SHELL=/bin/sh", "AWS_SECRET_KEY=s",
+		"NOEQUALS"}
+
+	tests := []struct {
+		name string
+		env  *Environment
+		want []string
+		err  bool
+	}{
+		{"unset", nil, base, false},
+		{"default allowlist", &Environment{}, []string{"HOME=/root", "LC_ALL=C", "LC_TIME=C", "PATH=/bin"}, false},
+		{"allowlist", &Environment{Allow: []string{"PATH", "SHELL"}}, []string{"PATH=/bin", "SHELL=/bin/sh"}, false},
+		{"allowlist pattern", &Environment{Allow: []string{"LC_*"}}, []string{"LC_ALL=C", "LC_TIME=C"}, false},
+		{"file", &Environment{File: file, Allow: []string{"HOME", "PATH"}},
+			[]string{"APP_TOKEN=abc", "HOME=/srv/app", "PATH=/bin"}, false},
+		{"optional file", &Environment{File: "-" + file, Allow: []string{"PATH"}},
+			[]string{"APP_TOKEN=abc", "HOME=/srv/app", "PATH=/bin"}, false},
+		{"missing optional file", &Environment{File: "-" + missing, Allow: []string{"PATH"}}, []string{"PATH=/bin"},
+			false},
+		{"missing file", &Environment{File: missing}, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := &Context{}
+			if test.env != nil {
+				ctx.SetEnvironment(*test.env)
+			}
+			env, err := ctx.daemonEnv(base)
+			if test.err {
+				if err == nil {
+					t.Errorf("daemonEnv() = %q, want an error", env)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(env, test.want) {
+				t.Errorf("daemonEnv() = %q, want %q", env, test.want)
+			}
+		})
+	}
+}
+
+// TestMaskedEnv checks that the values of secrets are masked in the logged environment, and that the variables
+// internal to this package and go-daemon are left out.
+func TestMaskedEnv(t *testing.T) {
+	env := []string{"PATH=/bin", "DB_PASSWORD=p", "api_token=t", "APP_KEY=k", "Private=v", "EMPTY=",
+		godaemon.MARK_NAME + "=1", startupEnv + "=/tmp/startup.sock", upgradeEnv + "=[]", "NOEQUALS"}
+
+	tests := []struct {
+		name    string
+		secrets []string
+		want    string
+	}{
+		{"default secrets", nil, "APP_KEY=******** DB_PASSWORD=******** EMPTY= PATH=/bin Private=v " +
+			"api_token=********"},
+		{"secrets", []string{"PRIVATE", "PATH"}, "APP_KEY=k DB_PASSWORD=p EMPTY= PATH=******** " +
+			"Private=******** api_token=t"},
+		{"secret patterns", []string{"*_KEY", "DB_*"}, "APP_KEY=******** DB_PASSWORD=******** EMPTY= PATH=/bin " +
+			"Private=v api_token=t"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := &Context{}
+			if test.secrets != nil {
+				ctx.SetEnvironment(Environment{Secrets: test.secrets})
+			}
+			if masked := ctx.maskedEnv(env); masked != test.want {
+				t.Errorf("maskedEnv() = %q, want %q", masked, test.want)
+			}
+		})
+	}
+}
+
+// TestEnvironInternal checks that the variables internal to this package and go-daemon are not in the environment
+// the daemon reads its configuration from.
+func TestEnvironInternal(t *testing.T) {
+	t.Setenv(startupEnv, "/tmp/startup.sock")
+	t.Setenv("APP_NAME", "test")
+	ctx := &Context{}
+	ctx.SetEnvironment(Environment{Allow: []string{"*"}})
+
+	found := false
+	for _, kv := range ctx.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if internalEnv(name) {
+			t.Errorf("Environ() has the internal variable %v", kv)
+		}
+		found = found || kv == "APP_NAME=test"
+	}
+	if !found {
+		t.Errorf("Environ() doesn't have APP_NAME=test")
+	}
+}
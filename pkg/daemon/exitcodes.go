@@ -0,0 +1,100 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+)
+
+// ExitCode is the exit status of a CLI command. The values are those of the LSB init script actions, so the daemon
+// binary can be driven directly by init scripts and configuration management tools; see
+// https://refspecs.linuxfoundation.org/LSB_5.0.0/LSB-Core-generic/LSB-Core-generic/iniscrptact.html.
+//
+// NOTE: as the LSB asks, "start" of a daemon that is already running and "stop" of a daemon that isn't running both
+// succeed. The "status" command has its own set of codes.
+type ExitCode int
+
+// Exit codes of every command except "status".
+const (
+	// ExitSuccess means the command succeeded.
+	ExitSuccess ExitCode = 0
+	// ExitFailure is a generic or unspecified error.
+	ExitFailure ExitCode = 1
+	// ExitInvalidArgument means invalid or excess arguments.
+	ExitInvalidArgument ExitCode = 2
+	// ExitUnimplemented means the daemon doesn't implement the command, e.g. "reload" without a reload handler.
+	ExitUnimplemented ExitCode = 3
+	// ExitPermissionDenied means the user doesn't have the privileges the command needs.
+	ExitPermissionDenied ExitCode = 4
+	// ExitNotInstalled means the program is not installed.
+	ExitNotInstalled ExitCode = 5
+	// ExitNotConfigured means the program is not configured, e.g. it has no worker.
+	ExitNotConfigured ExitCode = 6
+	// ExitNotRunning means the command needs a running daemon, and there isn't one.
+	ExitNotRunning ExitCode = 7
+)
+
+// Exit codes of the "status" command.
+const (
+	// StatusRunning means the daemon is running.
+	StatusRunning ExitCode = 0
+	// StatusDead means the daemon is dead, and its PID file was left behind.
+	StatusDead ExitCode = 1
+	// StatusNotRunning means the daemon is not running.
+	StatusNotRunning ExitCode = 3
+	// StatusUnknown means the status of the daemon can't be determined, e.g. the PID file can't be read.
+	StatusUnknown ExitCode = 4
+)
+
+// String returns the LSB description of a non-status exit code.
+func (code ExitCode) String() string {
+	switch code {
+	case ExitSuccess:
+		return "success"
+	case ExitFailure:
+		return "generic or unspecified error"
+	case ExitInvalidArgument:
+		return "invalid or excess argument(s)"
+	case ExitUnimplemented:
+		return "unimplemented feature"
+	case ExitPermissionDenied:
+		return "user had insufficient privilege"
+	case ExitNotInstalled:
+		return "program is not installed"
+	case ExitNotConfigured:
+		return "program is not configured"
+	case ExitNotRunning:
+		return "program is not running"
+	case ExitStopEscalated:
+		return "stopped by an escalated signal"
+	case ExitStopKilled:
+		return "stopped by SIGKILL"
+	default:
+		return fmt.Sprintf("exit status %d", int(code))
+	}
+}
+
+// exitCodeFor returns the exit code for a command that failed with err, mapping the errors returned by the lifecycle
+// methods onto their LSB codes. An *ExitError keeps its code, and any other error is ExitFailure.
+func exitCodeFor(err error) ExitCode {
+	if exitErr, ok := err.(*ExitError); ok {
+		return exitErr.Code
+	}
+	switch {
+	case err == ErrNoWorker:
+		return ExitNotConfigured
+	case err == ErrNotRunning:
+		return ExitNotRunning
+	case err == ErrReloadUnsupported:
+		return ExitUnimplemented
+	case os.IsPermission(err):
+		return ExitPermissionDenied
+	default:
+		return ExitFailure
+	}
+}
+
+// exitError returns err as an *ExitError with the exit code that suits it, with its message prefixed by format and
+// args.
+func exitError(err error, format string, args ...interface{}) *ExitError {
+	return &ExitError{Code: exitCodeFor(err), Err: fmt.Errorf("%v, %v", fmt.Sprintf(format, args...), err)}
+}
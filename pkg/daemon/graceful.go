@@ -0,0 +1,363 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	godaemon "github.com/sevlyar/go-daemon"
+	"github.com/sirupsen/logrus"
+)
+
+// upgradeEnv is the environment variable that marks a daemon process started by a graceful restart. Its value is the
+// JSON encoded list of the listeners passed on to the process.
+const upgradeEnv = "GO_DAEMONS_UPGRADE"
+
+// The file descriptors of a daemon process started by a graceful restart, after stdin, stdout and stderr. NOTE:
+// go-daemon leaves the PID file open on fd 4 of the daemon process, and the new process gets it on the same fd, so the
+// lock on the PID file is held throughout.
+const (
+	upgradeConnFd     = 3
+	upgradePidFileFd  = 4
+	upgradeControlFd  = 5
+	upgradeListenerFd = 6
+)
+
+// startProcess starts the new daemon process of a graceful restart.
+var startProcess = os.StartProcess
+
+// ErrUpgradeInProgress is returned when a graceful restart is asked for while another one is still in progress.
+var ErrUpgradeInProgress = errors.New("a graceful restart is already in progress")
+
+// UpgradeResult is the result of an "upgrade" control request, which performs a graceful restart.
+type UpgradeResult struct {
+	OldPid int `json:"old_pid"`
+	NewPid int `json:"new_pid"`
+}
+
+// listenerSpec identifies a listener by the arguments it was opened with.
+type listenerSpec struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+}
+
+// managedListener is a listener opened with Listen().
+type managedListener struct {
+	listenerSpec
+	listener net.Listener
+}
+
+// upgradeState is what a daemon process started by a graceful restart inherits from the old one. The PID file and the
+// control socket are only taken over once the old process has exited.
+type upgradeState struct {
+	conn      *os.File
+	pidFile   *godaemon.LockFile
+	control   net.Listener
+	inherited map[listenerSpec]*os.File
+	// tookOver is set, under Context.upgradeMu, once the old process has exited and the PID file has been taken over.
+	tookOver bool
+}
+
+// Listen is used by a run function in place of net.Listen for the sockets the daemon accepts connections on. On a
+// graceful restart these sockets are passed on to the new daemon process, where Listen returns them again, so no
+// connection is refused while the two processes swap over. The ctx parameter is the context passed to the run
+// function.
+func Listen(ctx context.Context, network string, address string) (net.Listener, error) {
+	state, ok := ctx.Value(stateKey{}).(*runState)
+	if !ok {
+		return net.Listen(network, address)
+	}
+	spec := listenerSpec{Network: network, Address: address}
+
+	var file *os.File
+	state.mu.Lock()
+	if state.upgrade != nil {
+		file = state.upgrade.inherited[spec]
+		delete(state.upgrade.inherited, spec)
+	}
+	state.mu.Unlock()
+
+	var listener net.Listener
+	var err error
+	if file != nil {
+		listener, err = net.FileListener(file)
+		_ = file.Close()
+	} else {
+		listener, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state.mu.Lock()
+	state.listeners = append(state.listeners, managedListener{listenerSpec: spec, listener: listener})
+	state.mu.Unlock()
+	return listener, nil
+}
+
+// upgrading returns true in a daemon process started by a graceful restart.
+func upgrading() bool {
+	_, ok := os.LookupEnv(upgradeEnv)
+	return ok
+}
+
+// gracefulRestart is the function used by the running daemon to perform a graceful restart. A new daemon process is
+// started with the command line of this one, and is passed this process's listeners, control socket and PID file. Once
+// it reports that it is ready the caller makes this process drain and exit; if it fails to start, or doesn't become
+// ready in time, it is killed and this process carries on as before.
+//
+// NOTE: the new process never gets a command line from the caller. Anyone in the group of the control socket could
+// otherwise run the daemon, maybe as root, with a configuration, PID file or settings of their own.
+func (ctx *Context) gracefulRestart(server *controlServer) (UpgradeResult, error) {
+	result := UpgradeResult{OldPid: os.Getpid()}
+	if ctx.foreground {
+		return result, fmt.Errorf("a graceful restart is not supported under a service manager")
+	}
+
+	ctx.upgradeMu.Lock()
+	if ctx.upgrading {
+		ctx.upgradeMu.Unlock()
+		return result, ErrUpgradeInProgress
+	}
+	ctx.upgrading = true
+	ctx.upgradeMu.Unlock()
+	defer func() {
+		ctx.upgradeMu.Lock()
+		ctx.upgrading = false
+		ctx.upgradeMu.Unlock()
+	}()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return result, err
+	}
+
+	// The files passed on are duplicates, so they can be closed here once the new process has been started.
+
+	conn, child, err := socketPair()
+	if err != nil {
+		return result, err
+	}
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr, child}
+	defer func() {
+		for _, file := range files[upgradeConnFd:] {
+			_ = file.Close()
+		}
+	}()
+
+	pidFd, err := syscall.Dup(upgradePidFileFd)
+	if err != nil {
+		_ = conn.Close()
+		return result, fmt.Errorf("cannot pass on the PID file, %v", err)
+	}
+	files = append(files, os.NewFile(uintptr(pidFd), ctx.goctx.PidFileName))
+
+	var specs []listenerSpec
+	listeners := append([]managedListener{{listener: server.listener}}, ctx.state.managedListeners()...)
+	for i, l := range listeners {
+		filer, ok := l.listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			_ = conn.Close()
+			return result, fmt.Errorf("cannot pass on a %v listener", l.Network)
+		}
+		file, err := filer.File()
+		if err != nil {
+			_ = conn.Close()
+			return result, err
+		}
+		files = append(files, file)
+		if i > 0 {
+			specs = append(specs, l.listenerSpec)
+		}
+	}
+	encoded, _ := json.Marshal(specs)
+
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, godaemon.MARK_NAME+"=") && !strings.HasPrefix(kv, upgradeEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, fmt.Sprintf("%v=%s", upgradeEnv, encoded))
+
+	wd, _ := os.Getwd()
+	proc, err := startProcess(exe, os.Args, &os.ProcAttr{
+		Dir:   wd,
+		Env:   env,
+		Files: files,
+		Sys:   &syscall.SysProcAttr{Setsid: true},
+	})
+	if err != nil {
+		_ = conn.Close()
+		return result, err
+	}
+	result.NewPid = proc.Pid
+	ctx.state.logf(logrus.InfoLevel, "Graceful restart, started the new daemon process with PID %v", proc.Pid)
+
+	// Wait for the new process to report that it's ready, to exit, or to run out of time.
+
+	exited := make(chan string, 1)
+	go func() {
+		state, err := proc.Wait()
+		if err != nil {
+			exited <- err.Error()
+		} else {
+			exited <- state.String()
+		}
+	}()
+	ready := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err == nil && line != "ready\n" {
+			err = fmt.Errorf("unexpected message %q", line)
+		}
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+		if err != nil {
+			err = fmt.Errorf("the new daemon process with PID %v failed to start, %v", proc.Pid, err)
+		}
+	case reason := <-exited:
+		err = fmt.Errorf("the new daemon process with PID %v failed to start, %v", proc.Pid, reason)
+	case <-time.After(ctx.getStartTimeout()):
+		err = fmt.Errorf("the new daemon process with PID %v was not ready after %v", proc.Pid, ctx.getStartTimeout())
+	}
+	if err != nil {
+		_ = proc.Kill()
+		_ = conn.Close()
+		ctx.state.logf(logrus.ErrorLevel, "Graceful restart failed, %v", err)
+		return result, err
+	}
+
+	// The new process takes over the PID file and the sockets once this one has exited, which it learns from conn
+	// being closed. Until then the sockets must outlive this process's listeners.
+
+	ctx.upgradeMu.Lock()
+	ctx.upgradeConn = conn
+	ctx.handedOver = true
+	ctx.upgradeMu.Unlock()
+	server.setHandedOver(true)
+	for _, l := range listeners {
+		if unixListener, ok := l.listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+	}
+	ctx.state.logf(logrus.InfoLevel, "Graceful restart, the new daemon process with PID %v is ready", proc.Pid)
+	return result, nil
+}
+
+// socketPair returns the two ends of a connected Unix-domain socket, the first of which is not inherited by child
+// processes.
+func socketPair() (*os.File, *os.File, error) {
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	return os.NewFile(uintptr(fds[0]), "upgrade"), os.NewFile(uintptr(fds[1]), "upgrade"), nil
+}
+
+// managedListeners returns the listeners opened with Listen().
+func (state *runState) managedListeners() []managedListener {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return append([]managedListener(nil), state.listeners...)
+}
+
+// runUpgraded is the function used by a daemon process started by a graceful restart. The files passed on by the old
+// daemon process are picked up, and then the daemon runs exactly as after a "start".
+func runUpgraded(ctx *Context) error {
+	var specs []listenerSpec
+	if err := json.Unmarshal([]byte(os.Getenv(upgradeEnv)), &specs); err != nil {
+		return fmt.Errorf("invalid %v, %v", upgradeEnv, err)
+	}
+	_ = os.Unsetenv(upgradeEnv)
+
+	controlFile := os.NewFile(upgradeControlFd, controlSocketPath(ctx))
+	control, err := net.FileListener(controlFile)
+	_ = controlFile.Close()
+	if err != nil {
+		return fmt.Errorf("cannot pick up the control socket, %v", err)
+	}
+
+	up := &upgradeState{
+		conn:      os.NewFile(upgradeConnFd, "upgrade"),
+		pidFile:   godaemon.NewLockFile(os.NewFile(upgradePidFileFd, ctx.goctx.PidFileName)),
+		control:   control,
+		inherited: make(map[listenerSpec]*os.File),
+	}
+	for i, spec := range specs {
+		up.inherited[spec] = os.NewFile(uintptr(upgradeListenerFd+i), spec.Address)
+	}
+	ctx.upgrade = up
+
+	// The PID file is only removed if this process took it over, and hasn't handed it on in turn. Before the take over
+	// it still belongs to the old process, which carries on when this one fails.
+	err = runDaemon(ctx)
+	ctx.upgradeMu.Lock()
+	remove := up.tookOver && !ctx.handedOver
+	ctx.upgradeMu.Unlock()
+	if remove {
+		_ = up.pidFile.Remove()
+	}
+	return err
+}
+
+// takeOver is the function used by a daemon process started by a graceful restart to wait for the old daemon process
+// to exit, and then take over the PID file and start serving the control socket.
+func (ctx *Context) takeOver(server *controlServer) {
+	_, _ = io.Copy(ioutil.Discard, ctx.upgrade.conn)
+	_ = ctx.upgrade.conn.Close()
+
+	ctx.upgradeMu.Lock()
+	ctx.upgrade.tookOver = true
+	ctx.upgradeMu.Unlock()
+	server.setHandedOver(false)
+
+	if err := ctx.upgrade.pidFile.WritePid(); err != nil {
+		ctx.state.logf(logrus.ErrorLevel, "Cannot write the PID file, %v", err)
+	} else if err = writePidRecord(ctx.goctx.PidFileName); err != nil {
+		ctx.state.logf(logrus.ErrorLevel, "Cannot record the daemon identity in the PID file, %v", err)
+	}
+	go server.serve()
+	ctx.state.logf(logrus.InfoLevel, "Took over from the old daemon process")
+}
+
+// reportUpgradeReady tells the old daemon process that this one is ready, and closes the inherited listeners that the
+// worker didn't ask for.
+func (state *runState) reportUpgradeReady() {
+	state.mu.Lock()
+	up := state.upgrade
+	var unused []*os.File
+	if up != nil {
+		for spec, file := range up.inherited {
+			unused = append(unused, file)
+			delete(up.inherited, spec)
+		}
+	}
+	state.mu.Unlock()
+
+	if up == nil {
+		return
+	}
+	for _, file := range unused {
+		_ = file.Close()
+	}
+	_, _ = up.conn.Write([]byte("ready\n"))
+}
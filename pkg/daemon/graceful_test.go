@@ -0,0 +1,52 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// TestUpgradeIgnoresRequestArgs checks that the new daemon process of a graceful restart gets the command line of the
+// running daemon, and never the arguments of the "upgrade" request.
+func TestUpgradeIgnoresRequestArgs(t *testing.T) {
+	// The PID file of a daemon is open on upgradePidFileFd, which is passed on to the new process.
+	fd, err := syscall.Dup(upgradePidFileFd)
+	if err != nil {
+		t.Skipf("fd %v isn't open in the test process, %v", upgradePidFileFd, err)
+	}
+	_ = syscall.Close(fd)
+
+	var started []string
+	defer func(f func(string, []string, *os.ProcAttr) (*os.Process, error)) { startProcess = f }(startProcess)
+	startProcess = func(name string, argv []string, attr *os.ProcAttr) (*os.Process, error) {
+		started = argv
+		return nil, errors.New("not started by the test")
+	}
+
+	ctx := &Context{}
+	if err := ctx.New(filepath.Join(t.TempDir(), "test.pid"), "", "/", "test"); err != nil {
+		t.Fatal(err)
+	}
+	newRunState(ctx, context.Background())
+	server, err := listenControl(ctx, make(chan os.Signal, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//noinspection GoUnhandledErrorResult
+	defer server.Close()
+
+	resp, err := callControl(ctx, "upgrade", []string{"test", "--config", "/tmp/other.json", "--set", "pid_dir=/tmp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.OK {
+		t.Fatalf("upgrade succeeded without a new process")
+	}
+	if !reflect.DeepEqual(started, os.Args) {
+		t.Errorf("new process started with %q, want the daemon's own %q", started, os.Args)
+	}
+}
@@ -0,0 +1,195 @@
+package daemon
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	godaemon "github.com/sevlyar/go-daemon"
+)
+
+// allApps is the app name that selects every app added to a Host.
+const allApps = "all"
+
+// Host runs several daemons from a single binary. Each app keeps its own Context, and with it its own PID file, log
+// file, control socket and signal handling; the Host only routes the command line to the right app.
+//
+// NOTE: an app that drops its privileges with SetRunAs needs PID file and log file directories of its own, it can't
+// hand a directory holding the files of the other apps over to its user.
+type Host struct {
+	name string
+	apps []*Context
+}
+
+// NewHost allocates a Host. The name is the name of the binary shown in the usage information.
+func NewHost(name string) *Host {
+	return &Host{name: name}
+}
+
+// Add adds an app to the host. The ctx must already have been set up with New, and its app name must be unique
+// within the host.
+func (h *Host) Add(ctx *Context) {
+	ctx.hosted = true
+	h.apps = append(h.apps, ctx)
+}
+
+// lookup returns the app called name, or nil if there is no such app.
+func (h *Host) lookup(name string) *Context {
+	for _, ctx := range h.apps {
+		if ctx.goctx.Args[0] == name {
+			return ctx
+		}
+	}
+	return nil
+}
+
+// ProcessCommandLine is the Host counterpart of the package level ProcessCommandLine. In the parent process it
+// handles "<binary> [global flags] <command> <app|all> [flags] [args...]" by running the command for each selected
+// app, and "<binary> list". In a daemon process it runs the app the daemon was started for; go-daemon, and a graceful
+// restart, start the daemon with the app name as its argv[0].
+func (h *Host) ProcessCommandLine() {
+	if godaemon.WasReborn() || upgrading() {
+		ctx := h.lookup(os.Args[0])
+		if ctx == nil {
+			log.Fatalf("Unknown app %v, aborting", os.Args[0])
+		}
+		ProcessCommandLine(ctx)
+		return
+	}
+
+	os.Exit(h.runCommandLine(os.Args[1:]))
+}
+
+// runCommandLine is the function used by the parent process to parse the command line arguments (without the program
+// name) and run the command for the selected apps. Returns the exit code for the process, which is the first non-zero
+// exit code of the apps.
+func (h *Host) runCommandLine(args []string) int {
+	options := &Context{}
+	global := options.newFlagSet(h.name)
+	if err := global.Parse(args); err != nil {
+		return h.usageError(err)
+	}
+	globalArgs := args[:len(args)-global.NArg()]
+
+	// Every app has a PID file of its own, so one given on the command line can only be for a single app.
+	pidFileErr := func(apps string) int {
+		return h.usageError(fmt.Errorf("--pidfile names the PID file of a single app, it cannot be used for %v", apps))
+	}
+
+	switch global.Arg(0) {
+	case "":
+		h.printUsage()
+		return int(ExitInvalidArgument)
+	case "help":
+		h.printUsage()
+		return int(ExitSuccess)
+	case "list":
+		if options.options.PidFile != "" {
+			return pidFileErr("list")
+		}
+		return h.list(globalArgs)
+	}
+	if global.NArg() < 2 {
+		return h.usageError(fmt.Errorf("missing app name for %q, use an app name or %q", global.Arg(0), allApps))
+	}
+	name, target, cmdArgs := global.Arg(0), global.Arg(1), global.Args()[2:]
+
+	apps := h.apps
+	if target != allApps {
+		ctx := h.lookup(target)
+		if ctx == nil {
+			return h.usageError(fmt.Errorf("unknown app %q", target))
+		}
+		apps = []*Context{ctx}
+	} else if name == "debug" || name == "foreground" {
+		return h.usageError(fmt.Errorf("%q can only run a single app", name))
+	} else if options.options.PidFile != "" {
+		return pidFileErr(fmt.Sprintf("%q", allApps))
+	}
+
+	code := 0
+	for _, ctx := range apps {
+		if len(apps) > 1 {
+			fmt.Printf("[%v]\n", ctx.goctx.Args[0])
+		}
+		appArgs := append(append(append([]string{}, globalArgs...), name), cmdArgs...)
+		if appCode := runCommandLine(ctx, appArgs); code == 0 {
+			code = appCode
+		}
+	}
+	return code
+}
+
+// list is the function used by the parent process to display the apps in the host, and whether they are running.
+// Each app finds its daemon with the global flags in globalArgs and its own paths, as its commands do. Returns the
+// exit code for the process, which is non-zero when the paths of an app can't be worked out.
+func (h *Host) list(globalArgs []string) int {
+	code := ExitSuccess
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "APP\tSTATE\tPID FILE\tLOG FILE")
+	for _, ctx := range h.apps {
+		state := "not running"
+		err := ctx.newFlagSet(ctx.goctx.Args[0]).Parse(globalArgs)
+		if err == nil {
+			err = ctx.applyOptions()
+		}
+		if err == nil {
+			err = ctx.applyPaths()
+		}
+		if err != nil {
+			state = err.Error()
+			if exitErr, ok := err.(*ExitError); ok && code == ExitSuccess {
+				code = exitErr.Code
+			} else if code == ExitSuccess {
+				code = exitCodeFor(err)
+			}
+		} else if proc := findDaemon(ctx); proc != nil {
+			state = fmt.Sprintf("running, PID %v", proc.Pid)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", ctx.goctx.Args[0], state, ctx.goctx.PidFileName, ctx.goctx.LogFileName)
+	}
+	_ = w.Flush()
+	return int(code)
+}
+
+// usageError prints a command line error followed by the usage information, and returns the exit code for it.
+func (h *Host) usageError(err error) int {
+	h.printUsage()
+	if err == flag.ErrHelp {
+		return int(ExitSuccess)
+	}
+	fmt.Printf("\n%v: %v\n", h.name, err)
+	return int(ExitInvalidArgument)
+}
+
+// printUsage is the function used by the parent process to display the command line usage information for the host.
+func (h *Host) printUsage() {
+	fmt.Printf("%[1]v runs several daemons from one binary\n\nUsage:\n\n", h.name)
+	fmt.Printf("\t%v [global flags] <command> <app|%v> [flags] [args...]\n", h.name, allApps)
+	fmt.Printf("\t%v [global flags] list\n\n", h.name)
+
+	fmt.Printf("The apps are:\n\n")
+	for _, ctx := range h.apps {
+		fmt.Printf("\t%v\n", ctx.goctx.Args[0])
+	}
+	fmt.Println()
+
+	// The built-in commands are the same for every app, the ones added with AddCommand and AddControlCommand are listed
+	// under their app.
+
+	fmt.Printf("The commands are:\n\n")
+	printCommandList(&Context{}, builtinCommands())
+	for _, ctx := range h.apps {
+		if len(ctx.extraCommands) == 0 && len(ctx.commands) == 0 {
+			continue
+		}
+		fmt.Printf("\nThe commands of %v are:\n\n", ctx.goctx.Args[0])
+		printCommandList(ctx, ctx.extraCommands)
+		printControlCommands(ctx)
+	}
+
+	fmt.Printf("\nThe global flags are:\n\n")
+	printFlags((&Context{}).newFlagSet(""), nil)
+}
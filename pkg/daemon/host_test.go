@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestHostListAppliesPaths checks that "list" finds each daemon at the PID file its paths handler gives, as its
+// commands do, and reports the apps whose paths can't be worked out.
+func TestHostListAppliesPaths(t *testing.T) {
+	dir := t.TempDir()
+	running := startTestDaemon(t, dir, "")
+
+	// The app starts out with a PID file in another directory, its paths handler moves it to the running daemon's.
+	app := newTestDaemon(t.TempDir(), "")
+	app.goctx.Args = []string{"app"}
+	app.SetPathsHandler(func(options Options) (Paths, error) {
+		return Paths{PidFile: running.goctx.PidFileName}, nil
+	})
+	broken := newTestDaemon(t.TempDir(), "")
+	broken.goctx.Args = []string{"broken"}
+	broken.SetPathsHandler(func(options Options) (Paths, error) {
+		return Paths{}, errors.New("no pid_dir")
+	})
+	host := NewHost("host")
+	host.Add(app)
+	host.Add(broken)
+
+	var code int
+	out := captureStdout(t, func() {
+		code = host.runCommandLine([]string{"list"})
+	})
+	if code != int(ExitNotConfigured) {
+		t.Errorf("list exit code %d, want %d", code, ExitNotConfigured)
+	}
+	for _, want := range []string{"app", "running, PID", running.goctx.PidFileName, "broken", "no pid_dir"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("list shows no %q:\n%v", want, out)
+		}
+	}
+}
+
+// TestHostRejectsPidFileForSeveralApps checks that --pidfile, which names the PID file of one app, isn't used for
+// every app.
+func TestHostRejectsPidFileForSeveralApps(t *testing.T) {
+	app := newTestDaemon(t.TempDir(), "")
+	host := NewHost("host")
+	host.Add(app)
+	pidFile := filepath.Join(t.TempDir(), "other.pid")
+
+	for _, args := range [][]string{
+		{"--pidfile", pidFile, "status", allApps},
+		{"--pidfile", pidFile, "list"},
+	} {
+		var code int
+		out := captureStdout(t, func() {
+			code = host.runCommandLine(args)
+		})
+		if code != int(ExitInvalidArgument) || !strings.Contains(out, "--pidfile") {
+			t.Errorf("%q exit code %d, want %d:\n%v", args, code, ExitInvalidArgument, out)
+		}
+	}
+
+	// A single app may still be given a PID file.
+	var code int
+	captureStdout(t, func() {
+		code = host.runCommandLine([]string{"--pidfile", pidFile, "status", "test"})
+	})
+	if code != int(StatusNotRunning) {
+		t.Errorf("status with --pidfile exit code %d, want %d", code, StatusNotRunning)
+	}
+}
+
+// captureStdout runs f, and returns what it printed on os.Stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	out := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		out <- string(data)
+	}()
+	f()
+	_ = w.Close()
+	return <-out
+}
@@ -0,0 +1,199 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrAlreadyRunning is returned by Start when there is already a daemon running.
+var ErrAlreadyRunning = errors.New("daemon is already running")
+
+// ErrNotRunning is returned by Stop, Reload and Status when there is no daemon running.
+var ErrNotRunning = errors.New("daemon is not running")
+
+// ErrStalePidFile is returned by Status when the daemon is dead, but its PID file was left behind. The stale PID file
+// is removed, so a later call returns ErrNotRunning.
+var ErrStalePidFile = errors.New("daemon is dead, but its PID file was left behind")
+
+// ErrReloadUnsupported is returned by Reload when neither SetReloadHandler nor SetConfigLoader has been called.
+var ErrReloadUnsupported = errors.New("daemon does not support reload functionality")
+
+// StopOptions are the options for Stop.
+type StopOptions struct {
+	// Timeout replaces the grace period of the first step of the StopPolicy, when it is not zero.
+	Timeout time.Duration
+}
+
+// RestartOptions are the options for Restart.
+type RestartOptions struct {
+	// Timeout replaces the grace period of the first step of the StopPolicy, when it is not zero. For a graceful
+	// restart it is how long to wait for the old daemon to drain.
+	Timeout time.Duration
+	// Graceful starts the new daemon, passing on its sockets, before the old one stops; see "restart --graceful".
+	Graceful bool
+}
+
+// RestartResult reports how a daemon was restarted.
+type RestartResult struct {
+	// OldPid is the process ID of the daemon that was replaced, or 0 if there wasn't one running.
+	OldPid int
+	// NewPid is the process ID of the new daemon.
+	NewPid int
+	// Stop is how the old daemon was stopped. It is nil when no daemon was running, and after a graceful restart,
+	// where the old daemon drains and exits by itself.
+	Stop *StopResult
+	// Draining is true when, after a graceful restart, the old daemon was still draining once the grace period was
+	// over.
+	Draining bool
+}
+
+// SetOutput is an optional method used to set where the lifecycle methods (Start, Stop, Restart, Reload and Status)
+// write their progress messages, such as the signals sent to the daemon. Progress messages are discarded by default;
+// the command line writes them to stdout.
+func (ctx *Context) SetOutput(w io.Writer) {
+	ctx.output = w
+}
+
+// printf writes a progress message to the output set by SetOutput.
+func (ctx *Context) printf(format string, args ...interface{}) {
+	if ctx.output != nil {
+		_, _ = fmt.Fprintf(ctx.output, format, args...)
+	}
+}
+
+// Start starts the daemon in the background, and returns its process. If the daemon is already running its process
+// is returned along with ErrAlreadyRunning, and ErrNoWorker is returned when no worker or run function has been
+// supplied.
+//
+// NOTE: the daemon is started by re-executing the running binary, exactly as "<daemon> start" does, so Start can
+// only be called from the daemon's own binary, which has to call ProcessCommandLine (or Host.ProcessCommandLine).
+func (ctx *Context) Start() (*os.Process, error) {
+	if proc := findDaemon(ctx); proc != nil {
+		return proc, ErrAlreadyRunning
+	}
+	if !ctx.hasWorker() {
+		return nil, ErrNoWorker
+	}
+	return startDaemon(ctx)
+}
+
+// Stop stops the running daemon, escalating through the steps of the StopPolicy, and returns which step ended the
+// daemon. ErrNotRunning is returned when there is no daemon running, and ErrStopFailed when it is still running
+// after every step.
+func (ctx *Context) Stop(opts StopOptions) (StopResult, error) {
+	proc := findDaemon(ctx)
+	if proc == nil {
+		return StopResult{}, ErrNotRunning
+	}
+	return stopDaemon(ctx, proc, ctx.stopPolicyFor(opts.Timeout))
+}
+
+// Restart stops the running daemon and starts a new one, or with opts.Graceful has the running daemon start its
+// replacement before it drains and exits. A daemon that isn't running is simply started.
+func (ctx *Context) Restart(opts RestartOptions) (RestartResult, error) {
+	var result RestartResult
+	proc := findDaemon(ctx)
+	if proc == nil {
+		newProc, err := ctx.Start()
+		if newProc != nil {
+			result.NewPid = newProc.Pid
+		}
+		return result, err
+	}
+	result.OldPid = proc.Pid
+
+	policy := ctx.stopPolicyFor(opts.Timeout)
+	if opts.Graceful {
+		return restartGraceful(ctx, proc, policy)
+	}
+
+	stopped, err := stopDaemon(ctx, proc, policy)
+	if err != nil {
+		return result, err
+	}
+	result.Stop = &stopped
+	ctx.printf("%v\n", stopped)
+
+	newProc, err := startDaemon(ctx)
+	if err != nil {
+		return result, err
+	}
+	result.NewPid = newProc.Pid
+	return result, nil
+}
+
+// restartGraceful asks the running daemon proc to start its replacement over its control socket, and then waits for
+// up to the grace period of the first step of policy for it to drain and exit. If the new daemon fails the running
+// daemon carries on, and an error is returned.
+func restartGraceful(ctx *Context, proc *os.Process, policy StopPolicy) (RestartResult, error) {
+	result := RestartResult{OldPid: proc.Pid}
+	startTime, _ := processStartTime(proc.Pid)
+
+	ctx.printf("Asking the daemon with PID %v to start its replacement\n", proc.Pid)
+	resp, err := callControlTimeout(ctx, "upgrade", nil, ctx.getStartTimeout()+controlTimeout)
+	if err != nil {
+		return result, fmt.Errorf("its control socket can't be reached, %v", err)
+	}
+	if !resp.OK {
+		return result, fmt.Errorf("the daemon with PID %v keeps running, %v", proc.Pid, resp.Error)
+	}
+	var upgraded UpgradeResult
+	_ = json.Unmarshal(resp.Result, &upgraded)
+	result.NewPid = upgraded.NewPid
+
+	ctx.printf("New daemon with PID %v is ready, waiting for PID %v to drain\n", upgraded.NewPid, proc.Pid)
+	grace := DefaultStopPolicy().Steps[0].Grace
+	if len(policy.Steps) > 0 {
+		grace = policy.Steps[0].Grace
+	}
+	if !waitForExit(ctx, proc, startTime, grace) {
+		ctx.printf("Daemon with PID %v is still draining\n", proc.Pid)
+		result.Draining = true
+	}
+	return result, nil
+}
+
+// Reload asks the running daemon to reload its configuration, and returns the configuration keys that changed.
+// The request goes over the control socket so the daemon's error can be returned; if the socket can't be reached
+// the daemon is signalled with SIGHUP instead, and ReloadResult.Signalled is set. ErrReloadUnsupported is
+// returned when the daemon has no reload handler or configuration loader, and ErrNotRunning when there is no daemon
+// running.
+func (ctx *Context) Reload() (ReloadResult, error) {
+	var result ReloadResult
+	if ctx.reloader == nil && ctx.configLoader == nil {
+		return result, ErrReloadUnsupported
+	}
+	proc := findDaemon(ctx)
+	if proc == nil {
+		return result, ErrNotRunning
+	}
+
+	result.Pid = proc.Pid
+	resp, err := callControl(ctx, "reload", nil)
+	if err != nil {
+		ctx.printf("Sending reload signal to daemon with PID, %v\n", proc.Pid)
+		result.Signalled = true
+		return result, proc.Signal(syscall.SIGHUP)
+	}
+	if !resp.OK {
+		return result, fmt.Errorf("%v", resp.Error)
+	}
+	_ = json.Unmarshal(resp.Result, &result)
+	return result, nil
+}
+
+// Status returns the status of the running daemon, as shown by "<daemon> status". ErrNotRunning is returned when
+// there is no daemon running, ErrStalePidFile when the daemon is dead, and the error reading the PID file when the
+// status can't be determined.
+func (ctx *Context) Status() (Status, error) {
+	proc, err := locateDaemon(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	return queryStatus(ctx, proc), nil
+}
@@ -0,0 +1,179 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestNotRunning checks the errors of the lifecycle methods when there is no daemon running, and the exit codes of
+// the commands built on them: as the LSB asks, stopping a daemon that isn't running succeeds.
+func TestNotRunning(t *testing.T) {
+	ctx := newTestDaemon(t.TempDir(), "")
+
+	if _, err := ctx.Status(); err != ErrNotRunning {
+		t.Errorf("Status() = %v, want %v", err, ErrNotRunning)
+	}
+	if _, err := ctx.Stop(StopOptions{}); err != ErrNotRunning {
+		t.Errorf("Stop() = %v, want %v", err, ErrNotRunning)
+	}
+	if _, err := ctx.Reload(); err != ErrNotRunning {
+		t.Errorf("Reload() = %v, want %v", err, ErrNotRunning)
+	}
+	if code := exitCodeFor(ErrNotRunning); code != ExitNotRunning {
+		t.Errorf("exit code for %v = %v, want %v", ErrNotRunning, code, ExitNotRunning)
+	}
+
+	checkExitCodes(t, ctx, map[string]ExitCode{
+		"status": StatusNotRunning,
+		"stop":   ExitSuccess,
+		"reload": ExitNotRunning,
+	})
+}
+
+// TestAlreadyRunning checks that starting a running daemon returns ErrAlreadyRunning with the running process, and
+// that, as the LSB asks, "start" then succeeds.
+func TestAlreadyRunning(t *testing.T) {
+	dir := t.TempDir()
+	ctx := startTestDaemon(t, dir, "")
+	status, err := ctx.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again := newTestDaemon(dir, "")
+	proc, err := again.Start()
+	if err != ErrAlreadyRunning {
+		t.Fatalf("Start() = %v, want %v", err, ErrAlreadyRunning)
+	}
+	if proc == nil || proc.Pid != status.Pid {
+		t.Errorf("Start() returned %+v, want the running daemon with PID %v", proc, status.Pid)
+	}
+
+	checkExitCodes(t, again, map[string]ExitCode{
+		"start":  ExitSuccess,
+		"status": StatusRunning,
+		"reload": ExitSuccess,
+	})
+}
+
+// TestStalePidFile checks that a PID file left behind by a dead daemon is reported with ErrStalePidFile and removed,
+// and that "status" then says the daemon is dead.
+func TestStalePidFile(t *testing.T) {
+	ctx := newTestDaemon(t.TempDir(), "")
+	writeStale := func() {
+		if err := ctx.makeDirs(); err != nil {
+			t.Fatal(err)
+		}
+		// The PID of the test process, in a PID file that nobody holds the lock on.
+		err := ioutil.WriteFile(ctx.goctx.PidFileName, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeStale()
+	if _, err := ctx.Status(); err != ErrStalePidFile {
+		t.Errorf("Status() = %v, want %v", err, ErrStalePidFile)
+	}
+	if _, err := os.Stat(ctx.goctx.PidFileName); !os.IsNotExist(err) {
+		t.Errorf("the stale PID file %v was not removed", ctx.goctx.PidFileName)
+	}
+	if _, err := ctx.Status(); err != ErrNotRunning {
+		t.Errorf("Status() after the stale PID file was removed = %v, want %v", err, ErrNotRunning)
+	}
+
+	writeStale()
+	checkExitCodes(t, ctx, map[string]ExitCode{"status": StatusDead})
+}
+
+// TestRunHandlerStopped checks that SIGTERM cancels the context of the run function, and that the daemon process
+// waits for the run function to return before it exits.
+func TestRunHandlerStopped(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(testModeEnv, "slow-stop")
+	ctx := startTestDaemon(t, dir, "")
+
+	signalTestDaemon(t, ctx, syscall.SIGTERM)
+	waitForFile(t, filepath.Join(dir, "cancelled"), "")
+	// The PID file is only released once the daemon process is done.
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := ctx.Status(); err == ErrNotRunning {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Status() 10s after SIGTERM = %v, want %v", err, ErrNotRunning)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "returned")); err != nil {
+		t.Errorf("the daemon process exited before the run function returned, %v", err)
+	}
+}
+
+// TestRunHandlerExitCode checks the exit code of a daemon process run in the foreground: zero once its run function
+// has returned after SIGTERM, and non-zero when the run function returns an error, whether stopped or not.
+func TestRunHandlerExitCode(t *testing.T) {
+	tests := []struct {
+		mode   string
+		stop   bool
+		code   int
+		output string
+	}{
+		{"slow-stop", true, 0, "Daemon test terminated normally"},
+		{"stop-error", true, int(ExitFailure), errTestStop.Error()},
+		{"fail", false, int(ExitFailure), errTestStartup.Error()},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			listener, err := listenStartup()
+			if err != nil {
+				t.Fatal(err)
+			}
+			//noinspection GoUnhandledErrorResult
+			defer listener.Close()
+
+			dir := t.TempDir()
+			var output bytes.Buffer
+			cmd := exec.Command(os.Args[0], "foreground")
+			cmd.Env = listener.env(append(os.Environ(), testDaemonEnv+"="+dir, testModeEnv+"="+test.mode))
+			cmd.Stdout, cmd.Stderr = &output, &output
+			if err = cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+			// The run function fails its start-up when it returns an error before it is ready.
+			if err = listener.wait(10*time.Second, nil); test.stop && err != nil {
+				_ = cmd.Process.Kill()
+				t.Fatalf("the daemon process isn't ready, %v", err)
+			}
+			if test.stop {
+				if err = cmd.Process.Signal(syscall.SIGTERM); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_ = cmd.Wait()
+			if code := cmd.ProcessState.ExitCode(); code != test.code {
+				t.Errorf("exit code %v, want %v; output:\n%s", code, test.code, output.String())
+			}
+			if !strings.Contains(output.String(), test.output) {
+				t.Errorf("output:\n%s\nwant %q", output.String(), test.output)
+			}
+		})
+	}
+}
+
+// checkExitCodes runs each command on the command line of ctx, and checks its exit code.
+func checkExitCodes(t *testing.T, ctx *Context, codes map[string]ExitCode) {
+	t.Helper()
+	for command, want := range codes {
+		if code := ExitCode(runCommandLine(ctx, []string{command})); code != want {
+			t.Errorf("%q exit code %d, want %d", command, code, want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package daemon
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Unlimited is the value of a ResourceLimit without a limit, RLIM_INFINITY.
+const Unlimited = ^uint64(0)
+
+// ioprioClassShift is the shift of the class in an I/O priority, see ioprio_set(2).
+const ioprioClassShift = 13
+
+// ioClasses are the I/O scheduling classes of ioprio_set(2), by their ionice(1) names.
+var ioClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// ResourceLimit is the soft and hard value of a resource limit, see setrlimit(2).
+type ResourceLimit struct {
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// String returns the limit as "soft/hard".
+func (l ResourceLimit) String() string {
+	value := func(v uint64) string {
+		if v == Unlimited {
+			return "unlimited"
+		}
+		return fmt.Sprintf("%d", v)
+	}
+	return value(l.Soft) + "/" + value(l.Hard)
+}
+
+// Resources are the resource limits and scheduling settings applied to the daemon process before its worker starts.
+// The zero value of each field leaves the setting the daemon process inherited untouched. Raising a hard limit,
+// lowering the nice value, or the OOM score adjustment, and moving to another cgroup need the daemon to be started
+// as root; the settings are applied before the privileges set with SetRunAs are dropped.
+type Resources struct {
+	// Limits are the resource limits to set, by their setrlimit(2) names, e.g. "RLIMIT_NOFILE". Use
+	// {Soft: 0, Hard: 0} for "RLIMIT_CORE" to disable core dumps.
+	Limits map[string]ResourceLimit
+	// Nice is the nice value, from -20 (the highest priority) to 19 (the lowest).
+	Nice *int
+	// IOClass is the I/O scheduling class, "realtime", "best-effort" or "idle", as for ionice(1). Only supported on
+	// Linux.
+	IOClass string
+	// IOLevel is the priority within the "realtime" and "best-effort" classes, from 0 (the highest) to 7. It needs
+	// IOClass to be set.
+	IOLevel int
+	// OOMScoreAdj is the OOM killer score adjustment, from -1000 (never kill) to 1000 (kill first). Only supported
+	// on Linux.
+	OOMScoreAdj *int
+	// Cgroup is the cgroup v2 the daemon process is moved to, as a path below the cgroup2 mount point, e.g.
+	// "/godaemons/helloworld". It is created when it doesn't exist. Only supported on Linux.
+	Cgroup string
+}
+
+// ProcessLimits are the effective resource limits and scheduling settings of the daemon process, as reported by
+// "<daemon> status". Limits always holds RLIMIT_NOFILE and RLIMIT_CORE, along with every limit set with
+// SetResources.
+type ProcessLimits struct {
+	Limits      map[string]ResourceLimit `json:"limits,omitempty"`
+	Nice        int                      `json:"nice"`
+	IOClass     string                   `json:"io_class,omitempty"`
+	IOLevel     int                      `json:"io_level,omitempty"`
+	OOMScoreAdj *int                     `json:"oom_score_adj,omitempty"`
+	Cgroup      string                   `json:"cgroup,omitempty"`
+}
+
+// String returns the limits and scheduling settings on one line.
+func (l ProcessLimits) String() string {
+	names := make([]string, 0, len(l.Limits))
+	for name := range l.Limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%v %v", strings.TrimPrefix(name, "RLIMIT_"), l.Limits[name]))
+	}
+	parts = append(parts, fmt.Sprintf("nice %v", l.Nice))
+	if l.IOClass != "" {
+		io := l.IOClass
+		if l.IOClass != "idle" && l.IOClass != "none" {
+			io = fmt.Sprintf("%v/%v", l.IOClass, l.IOLevel)
+		}
+		parts = append(parts, "I/O "+io)
+	}
+	if l.OOMScoreAdj != nil {
+		parts = append(parts, fmt.Sprintf("OOM score adj %v", *l.OOMScoreAdj))
+	}
+	if l.Cgroup != "" {
+		parts = append(parts, "cgroup "+l.Cgroup)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ioPriority returns the I/O scheduling class and level of the I/O priority prio of ioprio_get(2). The class is "none"
+// when the process has none, and its I/O priority follows its nice value.
+func ioPriority(prio int) (string, int) {
+	class := "none"
+	for name, c := range ioClasses {
+		if prio>>ioprioClassShift == c {
+			class = name
+		}
+	}
+	return class, prio & (1<<ioprioClassShift - 1)
+}
+
+// SetResources is an optional method used to set the resource limits, scheduling settings and cgroup of the daemon
+// process. They are applied in the daemon process, before the worker starts, and a setting that can't be applied
+// fails the start. Not used by "<daemon> debug".
+func (ctx *Context) SetResources(r Resources) {
+	ctx.resources = &r
+}
+
+// validate returns an error for the first setting that is out of range, or unknown on this platform.
+func (r Resources) validate() error {
+	for name, limit := range r.Limits {
+		if _, ok := rlimitResources[name]; !ok {
+			return fmt.Errorf("unknown resource limit %q", name)
+		}
+		if limit.Soft > limit.Hard {
+			return fmt.Errorf("the soft limit %v of %v is above its hard limit %v", limit.Soft, name, limit.Hard)
+		}
+	}
+	if r.Nice != nil && (*r.Nice < -20 || *r.Nice > 19) {
+		return fmt.Errorf("nice value %v is not between -20 and 19", *r.Nice)
+	}
+	if r.IOClass != "" {
+		if _, ok := ioClasses[r.IOClass]; !ok {
+			return fmt.Errorf("unknown I/O scheduling class %q", r.IOClass)
+		}
+	}
+	if r.IOLevel < 0 || r.IOLevel > 7 {
+		return fmt.Errorf("I/O priority level %v is not between 0 and 7", r.IOLevel)
+	}
+	if r.IOLevel != 0 && r.IOClass == "" {
+		return fmt.Errorf("I/O priority level %v without an I/O scheduling class", r.IOLevel)
+	}
+	if r.OOMScoreAdj != nil && (*r.OOMScoreAdj < -1000 || *r.OOMScoreAdj > 1000) {
+		return fmt.Errorf("OOM score adjustment %v is not between -1000 and 1000", *r.OOMScoreAdj)
+	}
+	if r.Cgroup != "" && !strings.HasPrefix(r.Cgroup, "/") {
+		return fmt.Errorf("cgroup %q is not an absolute path", r.Cgroup)
+	}
+	return nil
+}
+
+// applyResources is the function used by the daemon process to apply the settings of SetResources. Only the settings
+// that differ from the current ones are changed, so a daemon process started by a graceful restart, which inherits
+// them from the old one after it has dropped its privileges, isn't refused a change it doesn't need.
+func applyResources(ctx *Context) error {
+	if ctx.resources == nil {
+		return nil
+	}
+	r := *ctx.resources
+	if err := r.validate(); err != nil {
+		return err
+	}
+
+	// The cgroup comes first, as its controllers may cap what the other settings ask for.
+
+	current := currentLimits(r)
+	if r.Cgroup != "" && r.Cgroup != current.Cgroup {
+		if err := joinCgroup(r.Cgroup); err != nil {
+			return fmt.Errorf("cannot move to cgroup %v, %v", r.Cgroup, err)
+		}
+	}
+
+	names := make([]string, 0, len(r.Limits))
+	for name := range r.Limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if limit := r.Limits[name]; limit != current.Limits[name] {
+			if err := setRlimit(rlimitResources[name], limit); err != nil {
+				return fmt.Errorf("cannot set %v to %v, %v", name, limit, err)
+			}
+		}
+	}
+
+	if r.Nice != nil && *r.Nice != current.Nice {
+		if err := setNice(*r.Nice); err != nil {
+			return fmt.Errorf("cannot set the nice value to %v, %v", *r.Nice, err)
+		}
+	}
+	if r.IOClass != "" && (r.IOClass != current.IOClass || r.IOLevel != current.IOLevel) {
+		if err := setIOPriority(ioClasses[r.IOClass], r.IOLevel); err != nil {
+			return fmt.Errorf("cannot set the I/O scheduling class to %v, %v", r.IOClass, err)
+		}
+	}
+	if r.OOMScoreAdj != nil && (current.OOMScoreAdj == nil || *r.OOMScoreAdj != *current.OOMScoreAdj) {
+		if err := setOOMScoreAdj(*r.OOMScoreAdj); err != nil {
+			return fmt.Errorf("cannot set the OOM score adjustment to %v, %v", *r.OOMScoreAdj, err)
+		}
+	}
+	return nil
+}
+
+// currentLimits returns the effective limits and scheduling settings of the daemon process, including the limits
+// set by r.
+func currentLimits(r Resources) ProcessLimits {
+	names := []string{"RLIMIT_NOFILE", "RLIMIT_CORE"}
+	for name := range r.Limits {
+		names = append(names, name)
+	}
+
+	limits := processScheduling()
+	limits.Limits = make(map[string]ResourceLimit)
+	for _, name := range names {
+		if resource, ok := rlimitResources[name]; ok {
+			if limit, err := getRlimit(resource); err == nil {
+				limits.Limits[name] = limit
+			}
+		}
+	}
+	return limits
+}
+
+// currentLimits returns the effective limits and scheduling settings of the daemon process, for "<daemon> status".
+func (ctx *Context) currentLimits() *ProcessLimits {
+	var r Resources
+	if ctx.resources != nil {
+		r = *ctx.resources
+	}
+	limits := currentLimits(r)
+	return &limits
+}
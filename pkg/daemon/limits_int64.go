@@ -0,0 +1,21 @@
+//go:build freebsd || dragonfly
+// +build freebsd dragonfly
+
+package daemon
+
+import "syscall"
+
+// getRlimit returns the resource limit resource of the process. The limits are signed on these platforms, with
+// RLIM_INFINITY as -1, which converts to Unlimited.
+func getRlimit(resource int) (ResourceLimit, error) {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &rlim); err != nil {
+		return ResourceLimit{}, err
+	}
+	return ResourceLimit{Soft: uint64(rlim.Cur), Hard: uint64(rlim.Max)}, nil
+}
+
+// setRlimit sets the resource limit resource of the process.
+func setRlimit(resource int, limit ResourceLimit) error {
+	return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: int64(limit.Soft), Max: int64(limit.Hard)})
+}
@@ -0,0 +1,167 @@
+//go:build linux
+// +build linux
+
+package daemon
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioprioWhoProcess is IOPRIO_WHO_PROCESS, see ioprio_set(2).
+const ioprioWhoProcess = 1
+
+// defaultCgroupMount is where the cgroup2 hierarchy is mounted when /proc/self/mountinfo doesn't say otherwise.
+const defaultCgroupMount = "/sys/fs/cgroup"
+
+// rlimitResources are the resource limits of setrlimit(2), by name.
+var rlimitResources = map[string]int{
+	"RLIMIT_AS":         unix.RLIMIT_AS,
+	"RLIMIT_CORE":       unix.RLIMIT_CORE,
+	"RLIMIT_CPU":        unix.RLIMIT_CPU,
+	"RLIMIT_DATA":       unix.RLIMIT_DATA,
+	"RLIMIT_FSIZE":      unix.RLIMIT_FSIZE,
+	"RLIMIT_LOCKS":      unix.RLIMIT_LOCKS,
+	"RLIMIT_MEMLOCK":    unix.RLIMIT_MEMLOCK,
+	"RLIMIT_MSGQUEUE":   unix.RLIMIT_MSGQUEUE,
+	"RLIMIT_NICE":       unix.RLIMIT_NICE,
+	"RLIMIT_NOFILE":     unix.RLIMIT_NOFILE,
+	"RLIMIT_NPROC":      unix.RLIMIT_NPROC,
+	"RLIMIT_RSS":        unix.RLIMIT_RSS,
+	"RLIMIT_RTPRIO":     unix.RLIMIT_RTPRIO,
+	"RLIMIT_RTTIME":     unix.RLIMIT_RTTIME,
+	"RLIMIT_SIGPENDING": unix.RLIMIT_SIGPENDING,
+	"RLIMIT_STACK":      unix.RLIMIT_STACK,
+}
+
+// getRlimit returns the resource limit resource of the process.
+func getRlimit(resource int) (ResourceLimit, error) {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &rlim); err != nil {
+		return ResourceLimit{}, err
+	}
+	return ResourceLimit{Soft: rlim.Cur, Hard: rlim.Max}, nil
+}
+
+// setRlimit sets the resource limit resource of the process. Resource limits are shared by all the threads.
+func setRlimit(resource int, limit ResourceLimit) error {
+	return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: limit.Soft, Max: limit.Hard})
+}
+
+// forEachThread calls f for each thread of the process. On Linux the nice value and the I/O priority belong to a
+// thread, and a new thread inherits them from the thread that creates it, so the threads are listed again until no
+// new ones turn up.
+func forEachThread(f func(tid int) error) error {
+	done := make(map[int]bool)
+	for {
+		entries, err := ioutil.ReadDir("/proc/self/task")
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, entry := range entries {
+			tid, err := strconv.Atoi(entry.Name())
+			if err != nil || done[tid] {
+				continue
+			}
+			found = true
+			done[tid] = true
+			// A thread that has exited since the directory was read is no longer a concern.
+			if err = f(tid); err != nil && err != syscall.ESRCH {
+				return err
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+}
+
+// setNice sets the nice value of every thread of the process.
+func setNice(nice int) error {
+	return forEachThread(func(tid int) error {
+		return unix.Setpriority(unix.PRIO_PROCESS, tid, nice)
+	})
+}
+
+// setIOPriority sets the I/O scheduling class and level of every thread of the process.
+func setIOPriority(class int, level int) error {
+	prio := uintptr(class<<ioprioClassShift | level)
+	return forEachThread(func(tid int) error {
+		if _, _, errno := syscall.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), prio); errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+// setOOMScoreAdj sets the OOM killer score adjustment of the process.
+func setOOMScoreAdj(adj int) error {
+	return ioutil.WriteFile("/proc/self/oom_score_adj", []byte(strconv.Itoa(adj)), 0644)
+}
+
+// cgroupMount returns the mount point of the cgroup2 hierarchy.
+func cgroupMount() string {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return defaultCgroupMount
+	}
+	//noinspection GoUnhandledErrorResult
+	defer file.Close()
+
+	// The mount point is field 5, and the filesystem type follows the " - " separator.
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " - ", 2)
+		fields := strings.Fields(parts[0])
+		if len(parts) == 2 && len(fields) >= 5 && strings.HasPrefix(parts[1], "cgroup2 ") {
+			return fields[4]
+		}
+	}
+	return defaultCgroupMount
+}
+
+// joinCgroup moves the process, with all its threads, to the cgroup v2 path, creating it when it doesn't exist.
+func joinCgroup(path string) error {
+	dir := filepath.Join(cgroupMount(), filepath.Clean(path))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644)
+}
+
+// processScheduling returns the nice value, I/O priority, OOM score adjustment and cgroup v2 of the process.
+func processScheduling() ProcessLimits {
+	var limits ProcessLimits
+	if fields, err := readProcStat(os.Getpid()); err == nil {
+		// The nice value is field 19 in proc(5).
+		limits.Nice, _ = strconv.Atoi(fields[16])
+	}
+
+	if prio, _, errno := syscall.Syscall(unix.SYS_IOPRIO_GET, ioprioWhoProcess, 0, 0); errno == 0 {
+		limits.IOClass, limits.IOLevel = ioPriority(int(prio))
+	}
+
+	if data, err := ioutil.ReadFile("/proc/self/oom_score_adj"); err == nil {
+		if adj, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			limits.OOMScoreAdj = &adj
+		}
+	}
+
+	// The cgroup v2 hierarchy is the line with hierarchy ID 0, "0::/path".
+	if data, err := ioutil.ReadFile("/proc/self/cgroup"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "0::") {
+				limits.Cgroup = strings.TrimPrefix(line, "0::")
+			}
+		}
+	}
+	return limits
+}
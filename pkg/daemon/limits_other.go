@@ -0,0 +1,46 @@
+//go:build !linux
+// +build !linux
+
+package daemon
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// rlimitResources are the resource limits of setrlimit(2) common to the platforms Go supports, by name.
+var rlimitResources = map[string]int{
+	"RLIMIT_CORE":   syscall.RLIMIT_CORE,
+	"RLIMIT_CPU":    syscall.RLIMIT_CPU,
+	"RLIMIT_DATA":   syscall.RLIMIT_DATA,
+	"RLIMIT_FSIZE":  syscall.RLIMIT_FSIZE,
+	"RLIMIT_NOFILE": syscall.RLIMIT_NOFILE,
+	"RLIMIT_STACK":  syscall.RLIMIT_STACK,
+}
+
+// setNice sets the nice value of the process.
+func setNice(nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice)
+}
+
+// setIOPriority returns an error, I/O scheduling classes are a Linux feature.
+func setIOPriority(_ int, _ int) error {
+	return fmt.Errorf("I/O scheduling classes are only supported on Linux")
+}
+
+// setOOMScoreAdj returns an error, the OOM score adjustment is a Linux feature.
+func setOOMScoreAdj(_ int) error {
+	return fmt.Errorf("the OOM score adjustment is only supported on Linux")
+}
+
+// joinCgroup returns an error, cgroups are a Linux feature.
+func joinCgroup(_ string) error {
+	return fmt.Errorf("cgroups are only supported on Linux")
+}
+
+// processScheduling returns the nice value of the process. The other settings are Linux features.
+func processScheduling() ProcessLimits {
+	var limits ProcessLimits
+	limits.Nice, _ = syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	return limits
+}
@@ -0,0 +1,135 @@
+package daemon
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestResourcesValidate checks that a setting out of range, or unknown on this platform, is refused before anything is
+// applied.
+func TestResourcesValidate(t *testing.T) {
+	value := func(v int) *int {
+		return &v
+	}
+	tests := []struct {
+		name      string
+		resources Resources
+		err       string
+	}{
+		{"zero", Resources{}, ""},
+		{"limits", Resources{Limits: map[string]ResourceLimit{"RLIMIT_NOFILE": {1024, 4096},
+			"RLIMIT_CORE": {0, 0}, "RLIMIT_STACK": {Unlimited, Unlimited}}}, ""},
+		{"unknown limit", Resources{Limits: map[string]ResourceLimit{"RLIMIT_FILES": {1, 1}}},
+			`unknown resource limit "RLIMIT_FILES"`},
+		{"soft above hard", Resources{Limits: map[string]ResourceLimit{"RLIMIT_NOFILE": {4096, 1024}}},
+			"the soft limit 4096 of RLIMIT_NOFILE is above its hard limit 1024"},
+		{"soft unlimited", Resources{Limits: map[string]ResourceLimit{"RLIMIT_CORE": {Unlimited, 0}}},
+			"is above its hard limit 0"},
+		{"nice", Resources{Nice: value(-20)}, ""},
+		{"nice too low", Resources{Nice: value(-21)}, "nice value -21 is not between -20 and 19"},
+		{"nice too high", Resources{Nice: value(20)}, "nice value 20 is not between -20 and 19"},
+		{"I/O class", Resources{IOClass: "best-effort", IOLevel: 7}, ""},
+		{"unknown I/O class", Resources{IOClass: "low"}, `unknown I/O scheduling class "low"`},
+		{"I/O level too low", Resources{IOClass: "realtime", IOLevel: -1},
+			"I/O priority level -1 is not between 0 and 7"},
+		{"I/O level too high", Resources{IOClass: "realtime", IOLevel: 8},
+			"I/O priority level 8 is not between 0 and 7"},
+		{"I/O level without a class", Resources{IOLevel: 4},
+			"I/O priority level 4 without an I/O scheduling class"},
+		{"OOM score adjustment", Resources{OOMScoreAdj: value(1000)}, ""},
+		{"OOM score adjustment too low", Resources{OOMScoreAdj: value(-1001)},
+			"OOM score adjustment -1001 is not between -1000 and 1000"},
+		{"OOM score adjustment too high", Resources{OOMScoreAdj: value(1001)},
+			"OOM score adjustment 1001 is not between -1000 and 1000"},
+		{"cgroup", Resources{Cgroup: "/godaemons/test"}, ""},
+		{"relative cgroup", Resources{Cgroup: "godaemons/test"}, `cgroup "godaemons/test" is not an absolute path`},
+	}
+	for _, test := range tests {
+		err := test.resources.validate()
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%v: validate() = %v, want nil", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: validate() = %v, want an error with %q", test.name, err, test.err)
+		}
+	}
+}
+
+// TestProcessLimitsString checks the line of limits and scheduling settings written by "<daemon> status".
+func TestProcessLimitsString(t *testing.T) {
+	adj := -500
+	tests := []struct {
+		name   string
+		limits ProcessLimits
+		want   string
+	}{
+		{"zero", ProcessLimits{}, "nice 0"},
+		{"limits sorted", ProcessLimits{Limits: map[string]ResourceLimit{"RLIMIT_NOFILE": {1024, 4096},
+			"RLIMIT_CORE": {0, Unlimited}}}, "CORE 0/unlimited, NOFILE 1024/4096, nice 0"},
+		{"best-effort", ProcessLimits{Nice: 5, IOClass: "best-effort", IOLevel: 4}, "nice 5, I/O best-effort/4"},
+		{"idle", ProcessLimits{IOClass: "idle"}, "nice 0, I/O idle"},
+		{"none", ProcessLimits{IOClass: "none", IOLevel: 4}, "nice 0, I/O none"},
+		{"all", ProcessLimits{Limits: map[string]ResourceLimit{"RLIMIT_NOFILE": {Unlimited, Unlimited}}, Nice: -5,
+			IOClass: "realtime", OOMScoreAdj: &adj, Cgroup: "/godaemons/test"},
+			"NOFILE unlimited/unlimited, nice -5, I/O realtime/0, OOM score adj -500, cgroup /godaemons/test"},
+	}
+	for _, test := range tests {
+		if s := test.limits.String(); s != test.want {
+			t.Errorf("%v: String() = %q, want %q", test.name, s, test.want)
+		}
+	}
+}
+
+// TestIOPriority checks the class and level read from an I/O priority of ioprio_get(2).
+func TestIOPriority(t *testing.T) {
+	tests := []struct {
+		prio  int
+		class string
+		level int
+	}{
+		{0, "none", 0},
+		{4, "none", 4},
+		{1<<ioprioClassShift | 0, "realtime", 0},
+		{2<<ioprioClassShift | 7, "best-effort", 7},
+		{3<<ioprioClassShift | 0, "idle", 0},
+		{4<<ioprioClassShift | 2, "none", 2},
+	}
+	for _, test := range tests {
+		if class, level := ioPriority(test.prio); class != test.class || level != test.level {
+			t.Errorf("ioPriority(%#x) = %v/%v, want %v/%v", test.prio, class, level, test.class, test.level)
+		}
+	}
+}
+
+// TestProcessScheduling checks that the I/O priority of the current process reads back as it was set.
+func TestProcessScheduling(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("I/O scheduling classes are only supported on Linux")
+	}
+	before := processScheduling()
+	if before.IOClass == "" {
+		t.Skip("no ioprio_get(2)")
+	}
+	if _, ok := ioClasses[before.IOClass]; !ok && before.IOClass != "none" {
+		t.Errorf("processScheduling() I/O class = %q, want a known class", before.IOClass)
+	}
+
+	// Lowering the priority within best-effort needs no privileges.
+	if err := setIOPriority(ioClasses["best-effort"], 7); err != nil {
+		t.Skipf("cannot set the I/O priority, %v", err)
+	}
+	defer func() {
+		// A process without a class has no level of its own.
+		if before.IOClass == "none" {
+			before.IOLevel = 0
+		}
+		_ = setIOPriority(ioClasses[before.IOClass], before.IOLevel)
+	}()
+	if after := processScheduling(); after.IOClass != "best-effort" || after.IOLevel != 7 {
+		t.Errorf("processScheduling() after setting best-effort/7 = %v/%v", after.IOClass, after.IOLevel)
+	}
+}
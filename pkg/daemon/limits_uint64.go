@@ -0,0 +1,20 @@
+//go:build !linux && !freebsd && !dragonfly
+// +build !linux,!freebsd,!dragonfly
+
+package daemon
+
+import "syscall"
+
+// getRlimit returns the resource limit resource of the process.
+func getRlimit(resource int) (ResourceLimit, error) {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &rlim); err != nil {
+		return ResourceLimit{}, err
+	}
+	return ResourceLimit{Soft: rlim.Cur, Hard: rlim.Max}, nil
+}
+
+// setRlimit sets the resource limit resource of the process.
+func setRlimit(resource int, limit ResourceLimit) error {
+	return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: limit.Soft, Max: limit.Hard})
+}
@@ -0,0 +1,131 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PanicPolicy decides what the daemon does after a panic has been recovered in its worker, or in an orchestration
+// call run with Guard.
+type PanicPolicy int
+
+const (
+	// PanicExit makes the daemon exit with a non-zero status. This is the default for daemons without a supervisor.
+	PanicExit PanicPolicy = iota + 1
+	// PanicRestart restarts the worker, using the SupervisorPolicy set with SetSupervisor or
+	// DefaultSupervisorPolicy(). This is the default for supervised daemons.
+	PanicRestart
+	// PanicContinue returns a panic in an orchestration call to the worker as an error, so the worker carries on. A
+	// panic in the worker itself can't be continued from, and restarts the worker as for PanicRestart.
+	PanicContinue
+)
+
+// PanicError is the error recorded when a worker or orchestration call panics. Value is the value passed to panic(),
+// and Stack is the stack trace of the panicking goroutine.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+// Error returns the panic value as an error message.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// SetPanicPolicy is an optional method used to choose what the daemon does after a recovered panic. If not provided
+// the policy is PanicRestart for a daemon with a supervisor, and PanicExit otherwise.
+func (ctx *Context) SetPanicPolicy(p PanicPolicy) {
+	ctx.panicPolicy = p
+}
+
+// getPanicPolicy returns the panic policy for the daemon, falling back to the default policy.
+func (ctx *Context) getPanicPolicy() PanicPolicy {
+	switch {
+	case ctx.panicPolicy != 0:
+		return ctx.panicPolicy
+	case ctx.supervisor != nil:
+		return PanicRestart
+	default:
+		return PanicExit
+	}
+}
+
+// SetLogger is called by a run function to hand its logger to the framework, so recovered panics are written to the
+// daemon's own log along with their stack trace. The ctx parameter is the context passed to the run function.
+func SetLogger(ctx context.Context, logger *logrus.Logger) {
+	state, ok := ctx.Value(stateKey{}).(*runState)
+	if !ok {
+		return
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.logger = logger
+}
+
+// Guard runs a single orchestration call f on behalf of a run function, and recovers a panic inside it. The panic is
+// logged with its stack trace and counted. With PanicContinue the panic is returned as a *PanicError so the worker
+// can carry on; otherwise the worker is unwound and the daemon's panic policy applies. The ctx parameter is the
+// context passed to the run function. With any other context, e.g. in a unit test of the worker, there is no policy
+// to apply and the panic is always returned.
+func Guard(ctx context.Context, f func() error) (err error) {
+	state, _ := ctx.Value(stateKey{}).(*runState)
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		panicErr := &PanicError{Value: r, Stack: debug.Stack()}
+		state.recordPanic(panicErr, "orchestration")
+		if state != nil && state.panicPolicy != PanicContinue {
+			panic(panicErr)
+		}
+		err = panicErr
+	}()
+
+	return f()
+}
+
+// protect calls the worker f, turning a panic into a *PanicError. Panics already recorded by Guard are passed on
+// as-is.
+func (ctx *Context) protect(runCtx context.Context, f RunFunc) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		panicErr, ok := r.(*PanicError)
+		if !ok {
+			panicErr = &PanicError{Value: r, Stack: debug.Stack()}
+			ctx.state.recordPanic(panicErr, "worker")
+		}
+		err = panicErr
+	}()
+
+	return f(runCtx)
+}
+
+// recordPanic counts a recovered panic and logs it through the daemon's logger, or the standard logger when the run
+// function hasn't supplied one.
+func (state *runState) recordPanic(panicErr *PanicError, where string) {
+	if state == nil {
+		log.Printf("Recovered %v panic, %v\n%s", where, panicErr.Value, panicErr.Stack)
+		return
+	}
+
+	state.mu.Lock()
+	state.panicCount++
+	logger := state.logger
+	state.mu.Unlock()
+
+	if logger != nil {
+		logger.WithField("stack", string(panicErr.Stack)).Errorf("Recovered %v panic, %v", where, panicErr.Value)
+	} else {
+		log.Printf("Recovered %v panic, %v\n%s", where, panicErr.Value, panicErr.Stack)
+	}
+}
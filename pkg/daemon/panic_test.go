@@ -0,0 +1,136 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestGuard checks that a panic in an orchestration call is logged and counted, and then either returned to the
+// worker or passed on to the daemon's panic policy.
+func TestGuard(t *testing.T) {
+	tests := []struct {
+		policy   PanicPolicy
+		returned bool
+	}{
+		{PanicExit, false},
+		{PanicRestart, false},
+		{PanicContinue, true},
+	}
+	for _, test := range tests {
+		ctx := newTestDaemon(t.TempDir(), "")
+		ctx.SetPanicPolicy(test.policy)
+		runCtx := newRunState(ctx, context.Background())
+		var output bytes.Buffer
+		logger := logrus.New()
+		logger.Out = &output
+		SetLogger(runCtx, logger)
+
+		var err error
+		var unwound interface{}
+		func() {
+			defer func() {
+				unwound = recover()
+			}()
+			err = Guard(runCtx, func() error {
+				panic("boom")
+			})
+		}()
+
+		if test.returned {
+			if panicErr, ok := err.(*PanicError); !ok || panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+				t.Errorf("policy %v: Guard() = %#v, want the panic with its stack", test.policy, err)
+			}
+			if unwound != nil {
+				t.Errorf("policy %v: Guard() panicked with %v, want the panic returned", test.policy, unwound)
+			}
+		} else if panicErr, ok := unwound.(*PanicError); !ok || panicErr.Value != "boom" {
+			t.Errorf("policy %v: Guard() panicked with %#v and returned %v, want the panic passed on", test.policy,
+				unwound, err)
+		}
+		if ctx.state.panicCount != 1 {
+			t.Errorf("policy %v: %v panics counted, want 1", test.policy, ctx.state.panicCount)
+		}
+		if !strings.Contains(output.String(), "Recovered orchestration panic, boom") {
+			t.Errorf("policy %v: the logger wrote %q, want the panic", test.policy, output.String())
+		}
+	}
+}
+
+// TestGuardWithoutFramework checks that Guard returns the panic when it isn't called from a run function, and that an
+// error of the call is returned as-is.
+func TestGuardWithoutFramework(t *testing.T) {
+	err := Guard(context.Background(), func() error {
+		panic("boom")
+	})
+	if panicErr, ok := err.(*PanicError); !ok || panicErr.Value != "boom" {
+		t.Errorf("Guard() = %#v, want the panic", err)
+	}
+
+	errFailed := errors.New("failed")
+	if err = Guard(context.Background(), func() error { return errFailed }); err != errFailed {
+		t.Errorf("Guard() = %v, want %v", err, errFailed)
+	}
+}
+
+// TestProtect checks what becomes of a worker that panics, in an orchestration call run with Guard or outside of one,
+// under each panic policy: how often it runs, what the daemon's worker returns, and the panics and crashes counted.
+func TestProtect(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  PanicPolicy
+		guarded bool
+		runs    int
+		panic   bool
+		crashes int
+	}{
+		{"guarded under PanicExit", PanicExit, true, 1, true, 0},
+		{"guarded under PanicRestart", PanicRestart, true, 2, false, 1},
+		{"guarded under PanicContinue", PanicContinue, true, 1, false, 0},
+		{"unguarded under PanicExit", PanicExit, false, 1, true, 0},
+		{"unguarded under PanicRestart", PanicRestart, false, 2, false, 1},
+		// A panic outside of Guard can't be continued from, so the worker is restarted.
+		{"unguarded under PanicContinue", PanicContinue, false, 2, false, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := newTestDaemon(t.TempDir(), "")
+			ctx.SetSupervisor(SupervisorPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+			ctx.SetPanicPolicy(test.policy)
+
+			runs := 0
+			ctx.SetRunHandler(func(runCtx context.Context) error {
+				runs++
+				if runs > 1 {
+					return nil
+				}
+				if !test.guarded {
+					panic("boom")
+				}
+				if err := Guard(runCtx, func() error { panic("boom") }); err == nil {
+					t.Errorf("Guard() = nil, want the panic")
+				}
+				return nil
+			})
+			runCtx := newRunState(ctx, context.Background())
+
+			err := ctx.runFunc()(runCtx)
+			if panicErr, ok := err.(*PanicError); ok != test.panic || (ok && panicErr.Value != "boom") {
+				t.Errorf("the worker returned %v, want the panic %v", err, test.panic)
+			}
+			if runs != test.runs {
+				t.Errorf("the worker ran %v times, want %v", runs, test.runs)
+			}
+			status := ctx.status()
+			if status.Panics != 1 || status.Crashes != test.crashes {
+				t.Errorf("status has %v panics and %v crashes, want 1 and %v", status.Panics, status.Crashes,
+					test.crashes)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	godaemon "github.com/sevlyar/go-daemon"
+)
+
+// Modes of the directories created for the PID file and the log file. The PID file directory can be read by everyone,
+// so other users can run "<daemon> status" on a daemon root started (see Paths.SystemPidFile), and the log directory
+// by the group, as the log file.
+const (
+	pidDirMode os.FileMode = 0755
+	logDirMode os.FileMode = 0750
+)
+
+// Paths are the PID file, log file and working directory of the daemon, as passed to New.
+//
+// SystemPidFile is optional, the PID file the daemon has when root starts it, for a daemon whose default PID file
+// depends on the user. The commands that look for a running daemon, such as "status" and "stop", check it before
+// PidFile, so a user finds the daemon root started rather than reporting it isn't running. Only a PID file held by a
+// running daemon is used; a stale one is left for root to clean up.
+type Paths struct {
+	PidFile       string
+	LogFile       string
+	WorkingDir    string
+	SystemPidFile string
+}
+
+// PathsFunc is the function signature for deciding the paths of the daemon from the command line options. Used by
+// SetPathsHandler.
+type PathsFunc func(options Options) (Paths, error)
+
+// settingsFlag is the flag.Value of --set, which can be given more than once.
+type settingsFlag struct {
+	settings *[]string
+}
+
+// String returns the settings given so far.
+func (f settingsFlag) String() string {
+	if f.settings == nil {
+		return ""
+	}
+	return strings.Join(*f.settings, " ")
+}
+
+// Set adds a "key=value" setting.
+func (f settingsFlag) Set(value string) error {
+	*f.settings = append(*f.settings, value)
+	return nil
+}
+
+// SetPathsHandler is an optional method used to decide the PID file, log file and working directory once the command
+// line has been parsed, e.g. from the daemon's configuration file, instead of when New is called. f is called by every
+// command, in the parent process and again in the daemon process, before the paths are used. An empty field keeps the
+// path passed to New, and --pidfile still overrides the PID file. An error fails the command with ExitNotConfigured.
+func (ctx *Context) SetPathsHandler(f PathsFunc) {
+	ctx.pathsHandler = f
+}
+
+// Environ returns the environment the daemon runs with, for reading configuration from. In the daemon process it is
+// the process environment, elsewhere it is the environment "<daemon> start" gives the daemon, see SetEnvironment, so
+// the parent and the daemon agree on the configuration. If the environment file can't be read the process environment
+// is used; "<daemon> start" reports the error. The variables internal to this package are left out.
+func (ctx *Context) Environ() []string {
+	env := os.Environ()
+	if !godaemon.WasReborn() && !upgrading() {
+		if daemonEnv, err := ctx.daemonEnv(env); err == nil {
+			env = daemonEnv
+		}
+	}
+
+	var result []string
+	for _, kv := range env {
+		if !internalEnv(strings.SplitN(kv, "=", 2)[0]) {
+			result = append(result, kv)
+		}
+	}
+	return result
+}
+
+// applyPaths calls the handler set by SetPathsHandler, and applies the paths it returns.
+func (ctx *Context) applyPaths() error {
+	if ctx.pathsHandler == nil {
+		return nil
+	}
+	paths, err := ctx.pathsHandler(ctx.options)
+	if err != nil {
+		return &ExitError{Code: ExitNotConfigured, Err: fmt.Errorf("invalid configuration, %v", err)}
+	}
+	if paths.PidFile != "" && ctx.options.PidFile == "" {
+		ctx.goctx.PidFileName = paths.PidFile
+	}
+	if ctx.options.PidFile == "" {
+		ctx.systemPidFile = paths.SystemPidFile
+	}
+	if paths.LogFile != "" {
+		ctx.goctx.LogFileName = paths.LogFile
+	}
+	if paths.WorkingDir != "" {
+		ctx.goctx.WorkDir = paths.WorkingDir
+	}
+	return nil
+}
+
+// makeDirs creates the missing directories of the PID file and the log file, so neither has to be created by hand
+// before the daemon can start. Existing directories are left as they are, except in a directory anyone can write to,
+// see makePrivateDir. The error is an *ExitError, with ExitPermissionDenied when the user can't create a directory.
+func (ctx *Context) makeDirs() error {
+	for _, file := range []struct {
+		name string
+		mode os.FileMode
+	}{{ctx.goctx.PidFileName, pidDirMode}, {ctx.goctx.LogFileName, logDirMode}} {
+		if file.name == "" {
+			continue
+		}
+		dir := filepath.Dir(file.name)
+		var err error
+		if sharedDir(filepath.Dir(dir)) {
+			err = ctx.makePrivateDir(dir)
+		} else {
+			err = os.MkdirAll(dir, file.mode)
+		}
+		if err != nil {
+			return exitError(err, "cannot create the directory of %v", file.name)
+		}
+	}
+	return nil
+}
+
+// sharedDir returns true if anyone can create entries in dir, as in the temporary directory.
+func sharedDir(dir string) bool {
+	fi, err := os.Stat(dir)
+	return err == nil && fi.Mode().Perm()&0002 != 0
+}
+
+// makePrivateDir creates dir, in a directory anyone can write to, with mode 0700. Another user could have created it
+// first, e.g. the godaemons-<uid> directory configs uses without $XDG_RUNTIME_DIR, to swap the daemon's files, so an
+// existing one is only used if it is a directory, not a symbolic link, with mode 0700, owned by this user or by the
+// user the daemon runs as (see SetRunAs), which it is handed over to.
+func (ctx *Context) makePrivateDir(dir string) error {
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%v is not a directory", dir)
+	}
+	if fi.Mode().Perm() != 0700 {
+		return fmt.Errorf("%v has mode %#o, it has to be 0700 as it is in a directory anyone can write to", dir,
+			fi.Mode().Perm())
+	}
+
+	owners := []int{os.Geteuid()}
+	if ctx.runAs != nil {
+		if uid, _, _, err := lookupIdentity(*ctx.runAs); err == nil {
+			owners = append(owners, uid)
+		}
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		for _, uid := range owners {
+			if int(st.Uid) == uid {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v is owned by the user %v, another user could have created it", dir, st.Uid)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestSystemPidFileFirst checks that the commands looking for a running daemon find one running with the system PID
+// file, such as a daemon root started, before the daemon's own PID file.
+func TestSystemPidFileFirst(t *testing.T) {
+	running := startTestDaemon(t, t.TempDir(), "")
+	system := running.goctx.PidFileName
+
+	ctx := newTestDaemon(t.TempDir(), "")
+	own := ctx.goctx.PidFileName
+	ctx.SetPathsHandler(func(_ Options) (Paths, error) {
+		return Paths{SystemPidFile: system}, nil
+	})
+	if err := ctx.applyPaths(); err != nil {
+		t.Fatal(err)
+	}
+	status, err := ctx.Status()
+	if err != nil {
+		t.Fatalf("Status() = %v, want the daemon running with %v", err, system)
+	}
+	if want, _ := running.Status(); status.Pid != want.Pid {
+		t.Errorf("Status() found PID %v, want %v", status.Pid, want.Pid)
+	}
+	if ctx.goctx.PidFileName != system {
+		t.Errorf("the PID file is %v after the daemon was found, want %v", ctx.goctx.PidFileName, system)
+	}
+
+	// --pidfile names the PID file to use, the system one isn't looked at.
+	ctx = newTestDaemon(t.TempDir(), "")
+	ctx.options.PidFile = own
+	ctx.SetPathsHandler(func(_ Options) (Paths, error) {
+		return Paths{SystemPidFile: system}, nil
+	})
+	if err = ctx.applyOptions(); err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.applyPaths(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ctx.Status(); err != ErrNotRunning {
+		t.Errorf("Status() with --pidfile = %v, want %v", err, ErrNotRunning)
+	}
+}
+
+// TestStaleSystemPidFile checks that a stale system PID file is neither used nor removed, it belongs to root.
+func TestStaleSystemPidFile(t *testing.T) {
+	system := filepath.Join(t.TempDir(), "test.pid")
+	if err := ioutil.WriteFile(system, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := newTestDaemon(t.TempDir(), "")
+	own := ctx.goctx.PidFileName
+	ctx.SetPathsHandler(func(_ Options) (Paths, error) {
+		return Paths{SystemPidFile: system}, nil
+	})
+	if err := ctx.applyPaths(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.Status(); err != ErrNotRunning {
+		t.Errorf("Status() = %v, want %v", err, ErrNotRunning)
+	}
+	if ctx.goctx.PidFileName != own {
+		t.Errorf("the PID file is %v, want %v", ctx.goctx.PidFileName, own)
+	}
+	if _, err := os.Stat(system); err != nil {
+		t.Errorf("the stale system PID file was removed, %v", err)
+	}
+}
+
+// TestMakeDirsInSharedDir checks that a directory in a directory anyone can write to is created private, and that one
+// another user could have created, or tampered with, is refused.
+func TestMakeDirsInSharedDir(t *testing.T) {
+	shared := t.TempDir()
+	if err := os.Chmod(shared, 01777); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(shared, "godaemons-test")
+	ctx := newTestDaemon(t.TempDir(), "")
+	ctx.goctx.PidFileName = filepath.Join(dir, "test.pid")
+
+	if err := ctx.makeDirs(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Lstat(dir); err != nil || fi.Mode().Perm() != 0700 {
+		t.Fatalf("makeDirs created %v with %v, want mode 0700", dir, fi)
+	}
+	if err := ctx.makeDirs(); err != nil {
+		t.Errorf("makeDirs refused its own directory, %v", err)
+	}
+
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.makeDirs(); err == nil {
+		t.Errorf("makeDirs accepted %v with mode 0755", dir)
+	}
+
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(t.TempDir(), dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.makeDirs(); err == nil {
+		t.Errorf("makeDirs accepted %v, a symbolic link", dir)
+	}
+
+	if os.Geteuid() != 0 {
+		return
+	}
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(dir, 12345, 12345); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.makeDirs(); err == nil {
+		t.Errorf("makeDirs accepted %v, owned by another user", dir)
+	}
+}
+
+// TestMakeDirsPermissionDenied checks that a directory the user can't create fails "start" with ExitPermissionDenied,
+// as the LSB asks, rather than with a generic error.
+func TestMakeDirsPermissionDenied(t *testing.T) {
+	denied := &os.PathError{Op: "mkdir", Path: "/var/run/godaemons", Err: syscall.EACCES}
+	err := exitError(exitError(denied, "cannot create the directory of %v", "/var/run/godaemons/test.pid"),
+		"cannot start the daemon %v", "test")
+	if err.Code != ExitPermissionDenied {
+		t.Errorf("exit code for %v = %v, want %v", err, err.Code, ExitPermissionDenied)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("root can create the directory")
+	}
+	readOnly := t.TempDir()
+	if err := os.Chmod(readOnly, 0500); err != nil {
+		t.Fatal(err)
+	}
+	ctx := newTestDaemon(readOnly, "")
+	checkExitCodes(t, ctx, map[string]ExitCode{
+		"start":      ExitPermissionDenied,
+		"foreground": ExitPermissionDenied,
+	})
+}
@@ -0,0 +1,148 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	godaemon "github.com/sevlyar/go-daemon"
+)
+
+// pidRecord is the content of the daemon's PID file. go-daemon writes the process ID on the first line, and the daemon
+// adds a second line with the start time of the process (in clock ticks since boot, from /proc/<pid>/stat) and the
+// path of its executable. Together they tell the daemon apart from an unrelated process that was given the same
+// process ID after a crash or a reboot. StartTime is 0 and Exe is empty for PID files written without them.
+type pidRecord struct {
+	Pid       int
+	StartTime uint64
+	Exe       string
+}
+
+// readProcStat returns the fields of /proc/<pid>/stat that follow the command name. The command name in field 2 may
+// contain spaces, so the remaining fields are counted from the closing paren; fields[0] is field 3 (state) in proc(5).
+func readProcStat(pid int) ([]string, error) {
+	path := fmt.Sprintf("/proc/%d/stat", pid)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("unexpected format for %v", path)
+	}
+	return fields, nil
+}
+
+// processStartTime returns the start time of the process pid in clock ticks since boot.
+func processStartTime(pid int) (uint64, error) {
+	fields, err := readProcStat(pid)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// processExe returns the path of the executable of the process pid. The executable may have been replaced since the
+// process started, e.g. by an upgrade, so the " (deleted)" marker the kernel adds is removed.
+func processExe(pid int) (string, error) {
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(exe, " (deleted)"), nil
+}
+
+// writePidRecord is the function used by the daemon process to add its start time and executable to the PID file
+// created by go-daemon. Either is left out on systems without procfs.
+func writePidRecord(name string) error {
+	if name == "" {
+		return nil
+	}
+	startTime, _ := processStartTime(os.Getpid())
+	exe, _ := processExe(os.Getpid())
+
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer file.Close()
+	_, err = fmt.Fprintf(file, "\n%d %s\n", startTime, exe)
+	return err
+}
+
+// readPidRecord reads the PID file name.
+func readPidRecord(name string) (pidRecord, error) {
+	var rec pidRecord
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return rec, err
+	}
+
+	lines := strings.SplitN(string(data), "\n", 3)
+	if rec.Pid, err = strconv.Atoi(strings.TrimSpace(lines[0])); err != nil || rec.Pid <= 0 {
+		return rec, fmt.Errorf("PID file %v does not contain a process ID", name)
+	}
+	if len(lines) > 1 {
+		parts := strings.SplitN(strings.TrimSpace(lines[1]), " ", 2)
+		rec.StartTime, _ = strconv.ParseUint(parts[0], 10, 64)
+		if len(parts) > 1 {
+			rec.Exe = parts[1]
+		}
+	}
+	return rec, nil
+}
+
+// verify returns nil when the process rec.Pid is the process that wrote the PID file name, or an error saying why it
+// isn't. Without procfs only the existence of the process is checked. A PID file without a start time and executable
+// must also still be locked, as the daemon holds the lock for as long as it runs.
+func (rec pidRecord) verify(name string) error {
+	if err := syscall.Kill(rec.Pid, syscall.Signal(0)); err == syscall.ESRCH {
+		return fmt.Errorf("there is no process with PID %v", rec.Pid)
+	}
+	if rec.StartTime == 0 && rec.Exe == "" && !isLocked(name) {
+		return fmt.Errorf("PID %v does not hold the lock on the PID file", rec.Pid)
+	}
+	if rec.StartTime != 0 {
+		if startTime, err := processStartTime(rec.Pid); err == nil && startTime != rec.StartTime {
+			return fmt.Errorf("PID %v belongs to a process started after the daemon", rec.Pid)
+		}
+	}
+	if rec.Exe != "" {
+		if exe, err := processExe(rec.Pid); err == nil && exe != rec.Exe {
+			return fmt.Errorf("PID %v belongs to %v, not %v", rec.Pid, exe, rec.Exe)
+		}
+	}
+	return nil
+}
+
+// isLocked returns true if the file name is locked by another process.
+func isLocked(name string) bool {
+	file, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	//noinspection GoUnhandledErrorResult
+	defer file.Close()
+	return godaemon.NewLockFile(file).Lock() != nil
+}
+
+// removeStalePidFile removes a PID file left behind by a daemon that is no longer running. NOTE: a PID file that is
+// still locked belongs to a live process, most likely a daemon that is starting, and is never removed.
+func removeStalePidFile(name string) error {
+	file, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer file.Close()
+	if err = godaemon.NewLockFile(file).Lock(); err != nil {
+		return errors.New("it is locked by a running process")
+	}
+	return os.Remove(name)
+}
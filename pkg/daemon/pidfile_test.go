@@ -0,0 +1,174 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	godaemon "github.com/sevlyar/go-daemon"
+)
+
+// TestReadPidRecord checks the PID files written by go-daemon alone, and with the start time and executable the daemon
+// adds.
+func TestReadPidRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    pidRecord
+		err     bool
+	}{
+		{"legacy", "123\n", pidRecord{Pid: 123}, false},
+		{"legacy without newline", "123", pidRecord{Pid: 123}, false},
+		{"record", "123\n456 /usr/bin/app\n", pidRecord{Pid: 123, StartTime: 456, Exe: "/usr/bin/app"}, false},
+		{"exe with spaces", "123\n456 /opt/my app/app\n", pidRecord{Pid: 123, StartTime: 456, Exe: "/opt/my app/app"},
+			false},
+		{"start time only", "123\n456\n", pidRecord{Pid: 123, StartTime: 456}, false},
+		{"no start time", "123\n0 /usr/bin/app\n", pidRecord{Pid: 123, Exe: "/usr/bin/app"}, false},
+		{"empty second line", "123\n\n", pidRecord{Pid: 123}, false},
+		{"spaces", " 123 \n 456 /usr/bin/app \n", pidRecord{Pid: 123, StartTime: 456, Exe: "/usr/bin/app"}, false},
+		{"empty", "", pidRecord{}, true},
+		{"not a number", "abc\n", pidRecord{}, true},
+		{"zero", "0\n", pidRecord{}, true},
+		{"negative", "-5\n", pidRecord{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "test.pid")
+			if err := ioutil.WriteFile(name, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			rec, err := readPidRecord(name)
+			if test.err {
+				if err == nil {
+					t.Errorf("readPidRecord() = %+v, want an error", rec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec != test.want {
+				t.Errorf("readPidRecord() = %+v, want %+v", rec, test.want)
+			}
+		})
+	}
+
+	if _, err := readPidRecord(filepath.Join(t.TempDir(), "missing.pid")); !os.IsNotExist(err) {
+		t.Errorf("readPidRecord() of a missing file = %v, want it not to exist", err)
+	}
+}
+
+// TestPidRecordVerify checks that a PID file is only trusted when its process is the one that wrote it: a process
+// with another start time or executable has reused the PID, and a legacy PID file must still be locked.
+func TestPidRecordVerify(t *testing.T) {
+	pid := os.Getpid()
+	startTime, err := processStartTime(pid)
+	if err != nil {
+		t.Skipf("no procfs, %v", err)
+	}
+	exe, err := processExe(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		rec    pidRecord
+		locked bool
+		err    string
+	}{
+		{"record", pidRecord{Pid: pid, StartTime: startTime, Exe: exe}, false, ""},
+		{"start time only", pidRecord{Pid: pid, StartTime: startTime}, false, ""},
+		{"exe only", pidRecord{Pid: pid, Exe: exe}, false, ""},
+		{"start time mismatch", pidRecord{Pid: pid, StartTime: startTime + 1, Exe: exe}, false,
+			"started after the daemon"},
+		{"exe mismatch", pidRecord{Pid: pid, StartTime: startTime, Exe: "/usr/bin/other"}, false,
+			"not /usr/bin/other"},
+		{"legacy locked", pidRecord{Pid: pid}, true, ""},
+		{"legacy not locked", pidRecord{Pid: pid}, false, "does not hold the lock"},
+		{"no process", pidRecord{Pid: deadPid(t), StartTime: startTime, Exe: exe}, false, "there is no process"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "test.pid")
+			if err := ioutil.WriteFile(name, []byte(fmt.Sprintf("%d\n", test.rec.Pid)), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if test.locked {
+				lockFile(t, name)
+			}
+			err := test.rec.verify(name)
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("verify() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("verify() = %v, want an error with %q", err, test.err)
+			}
+		})
+	}
+}
+
+// TestRemoveStalePidFile checks that a PID file nobody holds the lock on is removed, and that a locked one is kept.
+func TestRemoveStalePidFile(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "stale.pid")
+	if err := ioutil.WriteFile(stale, []byte("123\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeStalePidFile(stale); err != nil {
+		t.Errorf("removeStalePidFile() of a stale PID file = %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("the stale PID file is still there, %v", err)
+	}
+
+	locked := filepath.Join(dir, "locked.pid")
+	if err := ioutil.WriteFile(locked, []byte("123\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lockFile(t, locked)
+	if err := removeStalePidFile(locked); err == nil {
+		t.Errorf("removeStalePidFile() of a locked PID file succeeded")
+	}
+	if _, err := os.Stat(locked); err != nil {
+		t.Errorf("the locked PID file was removed, %v", err)
+	}
+
+	if err := removeStalePidFile(filepath.Join(dir, "missing.pid")); !os.IsNotExist(err) {
+		t.Errorf("removeStalePidFile() of a missing file = %v, want it not to exist", err)
+	}
+}
+
+// lockFile locks the file name, as a running daemon does its PID file, until the test ends.
+func lockFile(t *testing.T, name string) {
+	t.Helper()
+	file, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := godaemon.NewLockFile(file)
+	if err = lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = lock.Unlock()
+		_ = file.Close()
+	})
+}
+
+// deadPid returns the PID of a process that has exited.
+func deadPid(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run true, %v", err)
+	}
+	return cmd.Process.Pid
+}
@@ -0,0 +1,173 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// RunAs is the identity the daemon process switches to before its worker starts. The daemon has to be started as
+// root for the switch to be possible.
+type RunAs struct {
+	// User is the user name, or numeric user ID, to run as.
+	User string
+	// Group is the group name, or numeric group ID, to run as. If empty the user's primary group is used.
+	Group string
+	// Capabilities are the Linux capabilities to keep after the switch, e.g. "CAP_NET_BIND_SERVICE". Only supported
+	// on Linux.
+	Capabilities []string
+}
+
+// ProcessIdentity is the effective identity of the daemon process, as reported by "<daemon> status".
+type ProcessIdentity struct {
+	UID          int      `json:"uid"`
+	User         string   `json:"user,omitempty"`
+	GID          int      `json:"gid"`
+	Group        string   `json:"group,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// SetRunAs is an optional method used to make the daemon give up root once it has started. The PID file, log file and
+// control socket are created as root and handed over to the new identity, then the supplementary groups, group and
+// user are switched before the worker starts. Not used by "<daemon> debug".
+//
+// NOTE: the directories of the PID file and the log file are handed over too, so they must not hold the files of
+// other daemons, see handOverDir. The apps of a Host share the default directories, so an app added to a Host with
+// SetRunAs needs directories of its own, e.g. /var/run/godaemons/<app> and /var/log/godaemons/<app>.
+func (ctx *Context) SetRunAs(runAs RunAs) {
+	ctx.runAs = &runAs
+}
+
+// lookupIdentity resolves runAs into numeric user, group and supplementary group IDs.
+func lookupIdentity(runAs RunAs) (uid int, gid int, groups []int, err error) {
+	u, err := user.Lookup(runAs.User)
+	if err != nil {
+		if u, err = user.LookupId(runAs.User); err != nil {
+			return 0, 0, nil, fmt.Errorf("unknown user %q", runAs.User)
+		}
+	}
+	uid, _ = strconv.Atoi(u.Uid)
+	gid, _ = strconv.Atoi(u.Gid)
+
+	if runAs.Group != "" {
+		g, err := user.LookupGroup(runAs.Group)
+		if err != nil {
+			if g, err = user.LookupGroupId(runAs.Group); err != nil {
+				return 0, 0, nil, fmt.Errorf("unknown group %q", runAs.Group)
+			}
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	groups = []int{gid}
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if n, err := strconv.Atoi(id); err == nil && n != gid {
+				groups = append(groups, n)
+			}
+		}
+	}
+	return uid, gid, groups, nil
+}
+
+// dropPrivileges is the function used by the daemon process to switch to the identity set with SetRunAs. The files in
+// paths that exist, and their directories, are chowned to the new identity first, so the daemon can still write to
+// them, remove its PID file and control socket when it stops, and create its log file again after a rotation; see
+// handOverDir.
+func dropPrivileges(ctx *Context, paths ...string) error {
+	if ctx.runAs == nil {
+		return nil
+	}
+
+	uid, gid, groups, err := lookupIdentity(*ctx.runAs)
+	if err != nil {
+		return err
+	}
+	// Already running as the user, e.g. after a graceful restart, which inherits the capabilities as ambient ones.
+	if os.Geteuid() == uid && (uid != 0 || len(ctx.runAs.Capabilities) == 0) {
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("cannot switch to user %v, the daemon is not running as root", ctx.runAs.User)
+	}
+
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err = os.Chown(path, uid, gid); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err = handOverDir(filepath.Dir(path), paths, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	return setIdentity(uid, gid, groups, ctx.runAs.Capabilities)
+}
+
+// handOverDir chowns dir, the directory of some of the daemon's files, to the new identity. Only a directory that holds
+// nothing but the daemon's files is handed over, as the new identity could otherwise replace the files of others:
+// their names have to start with the name of one of the files in paths, without its extension, followed by a '.' or a
+// '-', e.g. helloworld.log.1 and helloworld-diagnostics-<time>.txt. The directories created on start are empty, and a
+// directory that is shared, such as the default directory of the apps of a Host, is an error.
+func handOverDir(dir string, paths []string, uid int, gid int) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) == uid && int(st.Gid) == gid {
+		return nil
+	}
+
+	var prefixes []string
+	for _, path := range paths {
+		if path != "" {
+			base := filepath.Base(path)
+			prefixes = append(prefixes, strings.TrimSuffix(base, filepath.Ext(base)))
+		}
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !ownedEntry(entry.Name(), prefixes) {
+			return fmt.Errorf("cannot hand %v over to the daemon's user, it holds %v as well; give the daemon a "+
+				"directory of its own", dir, entry.Name())
+		}
+	}
+	return os.Chown(dir, uid, gid)
+}
+
+// ownedEntry returns true if name is the name of one of the daemon's files, see handOverDir.
+func ownedEntry(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix+".") || strings.HasPrefix(name, prefix+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// currentIdentity returns the effective identity of the running process.
+func currentIdentity() *ProcessIdentity {
+	id := &ProcessIdentity{UID: os.Geteuid(), GID: os.Getegid(), Capabilities: effectiveCapabilities()}
+	if u, err := user.LookupId(strconv.Itoa(id.UID)); err == nil {
+		id.User = u.Username
+	}
+	if g, err := user.LookupGroupId(strconv.Itoa(id.GID)); err == nil {
+		id.Group = g.Name
+	}
+	return id
+}
@@ -0,0 +1,183 @@
+//go:build linux
+// +build linux
+
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// linuxCapabilityVersion3 is _LINUX_CAPABILITY_VERSION_3 from linux/capability.h.
+const linuxCapabilityVersion3 = 0x20080522
+
+// prSetKeepCaps is PR_SET_KEEPCAPS from linux/prctl.h.
+const prSetKeepCaps = 8
+
+// prCapAmbient and prCapAmbientRaise are PR_CAP_AMBIENT and PR_CAP_AMBIENT_RAISE from linux/prctl.h.
+const (
+	prCapAmbient      = 47
+	prCapAmbientRaise = 2
+)
+
+// capabilities maps the Linux capability names onto their bit numbers, from linux/capability.h.
+var capabilities = map[string]uint{
+	"CAP_CHOWN":              0,
+	"CAP_DAC_OVERRIDE":       1,
+	"CAP_DAC_READ_SEARCH":    2,
+	"CAP_FOWNER":             3,
+	"CAP_FSETID":             4,
+	"CAP_KILL":               5,
+	"CAP_SETGID":             6,
+	"CAP_SETUID":             7,
+	"CAP_SETPCAP":            8,
+	"CAP_LINUX_IMMUTABLE":    9,
+	"CAP_NET_BIND_SERVICE":   10,
+	"CAP_NET_BROADCAST":      11,
+	"CAP_NET_ADMIN":          12,
+	"CAP_NET_RAW":            13,
+	"CAP_IPC_LOCK":           14,
+	"CAP_IPC_OWNER":          15,
+	"CAP_SYS_MODULE":         16,
+	"CAP_SYS_RAWIO":          17,
+	"CAP_SYS_CHROOT":         18,
+	"CAP_SYS_PTRACE":         19,
+	"CAP_SYS_PACCT":          20,
+	"CAP_SYS_ADMIN":          21,
+	"CAP_SYS_BOOT":           22,
+	"CAP_SYS_NICE":           23,
+	"CAP_SYS_RESOURCE":       24,
+	"CAP_SYS_TIME":           25,
+	"CAP_SYS_TTY_CONFIG":     26,
+	"CAP_MKNOD":              27,
+	"CAP_LEASE":              28,
+	"CAP_AUDIT_WRITE":        29,
+	"CAP_AUDIT_CONTROL":      30,
+	"CAP_SETFCAP":            31,
+	"CAP_MAC_OVERRIDE":       32,
+	"CAP_MAC_ADMIN":          33,
+	"CAP_SYSLOG":             34,
+	"CAP_WAKE_ALARM":         35,
+	"CAP_BLOCK_SUSPEND":      36,
+	"CAP_AUDIT_READ":         37,
+	"CAP_PERFMON":            38,
+	"CAP_BPF":                39,
+	"CAP_CHECKPOINT_RESTORE": 40,
+}
+
+// capHeader is struct __user_cap_header_struct from linux/capability.h.
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+// capData is struct __user_cap_data_struct from linux/capability.h. Version 3 uses two of them for 64 bits.
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// capabilityMask converts capability names, with or without the CAP_ prefix, into a bit mask.
+func capabilityMask(names []string) (uint64, error) {
+	var mask uint64
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		if !strings.HasPrefix(name, "CAP_") {
+			name = "CAP_" + name
+		}
+		bit, ok := capabilities[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown capability %q", name)
+		}
+		mask |= 1 << bit
+	}
+	return mask, nil
+}
+
+// setIdentity switches every thread of the process to the given user, group and supplementary groups, keeping only
+// the capabilities named in keep.
+func setIdentity(uid int, gid int, groups []int, keep []string) error {
+	mask, err := capabilityMask(keep)
+	if err != nil {
+		return err
+	}
+
+	// Without PR_SET_KEEPCAPS the permitted capabilities are cleared by setuid, and can't be raised again. NOTE: the Go
+	// runtime can only apply these calls to all threads in a binary built with CGO_ENABLED=0.
+	if mask != 0 {
+		if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetKeepCaps, 1, 0); errno != 0 {
+			if errno == syscall.ENOTSUP {
+				return fmt.Errorf("keeping capabilities needs a binary built with CGO_ENABLED=0")
+			}
+			return fmt.Errorf("prctl(PR_SET_KEEPCAPS), %v", errno)
+		}
+	}
+	if err = syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups, %v", err)
+	}
+	if err = syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid, %v", err)
+	}
+	if err = syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid, %v", err)
+	}
+	if mask == 0 {
+		return nil
+	}
+
+	header := capHeader{version: linuxCapabilityVersion3}
+	data := [2]capData{
+		{effective: uint32(mask), permitted: uint32(mask), inheritable: uint32(mask)},
+		{effective: uint32(mask >> 32), permitted: uint32(mask >> 32), inheritable: uint32(mask >> 32)},
+	}
+	_, _, errno := syscall.AllThreadsSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)),
+		uintptr(unsafe.Pointer(&data[0])), 0)
+	runtime.KeepAlive(&header)
+	runtime.KeepAlive(&data)
+	if errno != 0 {
+		return fmt.Errorf("capset, %v", errno)
+	}
+
+	// Ambient capabilities survive the exec of a graceful restart. They need Linux 4.3, and are only raised if possible.
+	for _, bit := range capabilities {
+		if mask&(1<<bit) != 0 {
+			_, _, _ = syscall.AllThreadsSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientRaise, uintptr(bit), 0, 0, 0)
+		}
+	}
+	return nil
+}
+
+// effectiveCapabilities returns the names of the effective capabilities of the running process, read from
+// /proc/self/status.
+func effectiveCapabilities() []string {
+	data, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return nil
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "CapEff:") {
+			continue
+		}
+		mask, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+		if err != nil {
+			return nil
+		}
+		var names []string
+		for name, bit := range capabilities {
+			if mask&(1<<bit) != 0 {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+// +build !linux
+
+package daemon
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setIdentity switches the process to the given user, group and supplementary groups. Capabilities are a Linux
+// feature, so asking to keep any is an error.
+func setIdentity(uid int, gid int, groups []int, keep []string) error {
+	if len(keep) > 0 {
+		return fmt.Errorf("keeping capabilities is only supported on Linux")
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups, %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid, %v", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid, %v", err)
+	}
+	return nil
+}
+
+// effectiveCapabilities returns nil, capabilities are a Linux feature.
+func effectiveCapabilities() []string {
+	return nil
+}
@@ -0,0 +1,118 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestDropPrivilegesThenStop checks that a daemon that gave up root removes its PID file and control socket when it
+// is stopped, so it is reported as not running rather than dead.
+func TestDropPrivilegesThenStop(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("dropping privileges needs root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skipf("no user to run as, %v", err)
+	}
+
+	// The directory has to be reachable by the daemon once it runs as nobody.
+	dir, err := ioutil.TempDir("", "daemon-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//noinspection GoUnhandledErrorResult
+	defer os.RemoveAll(dir)
+	if err = os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := startTestDaemon(t, dir, nobody.Username)
+	status, err := ctx.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Identity == nil || strconv.Itoa(status.Identity.UID) != nobody.Uid {
+		t.Fatalf("daemon runs as %+v, want %v", status.Identity, nobody.Username)
+	}
+	fi, err := os.Stat(filepath.Dir(ctx.goctx.PidFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := fi.Sys().(*syscall.Stat_t).Uid; strconv.Itoa(int(uid)) != nobody.Uid {
+		t.Errorf("PID file directory owned by %v, want %v", uid, nobody.Uid)
+	}
+
+	if _, err = ctx.Stop(StopOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{ctx.goctx.PidFileName, controlSocketPath(ctx)} {
+		if _, err = os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%v left behind by the stopped daemon", name)
+		}
+	}
+	if _, err = ctx.Status(); err != ErrNotRunning {
+		t.Errorf("Status() of the stopped daemon = %v, want %v", err, ErrNotRunning)
+	}
+}
+
+// TestHandOverDirRefusesSharedDir checks that a directory holding files other than the daemon's is not handed over.
+func TestHandOverDirRefusesSharedDir(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "test.pid")
+	for _, name := range []string{"test.pid", "test.sock", "test-diagnostics-20190101T000000.000Z.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	if err := handOverDir(dir, []string{pidFile}, uid, gid); err != nil {
+		t.Errorf("the daemon's own directory was refused, %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "other.pid"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := handOverDir(dir, []string{pidFile}, uid+1, gid); err == nil {
+		t.Errorf("a directory shared with other.pid was handed over")
+	}
+}
+
+// TestHandOverDirHostedApps checks that an app of a Host can't hand over the directory it shares with the other apps,
+// and that it can hand over a directory of its own.
+func TestHandOverDirHostedApps(t *testing.T) {
+	shared := t.TempDir()
+	one, two := filepath.Join(shared, "one.pid"), filepath.Join(shared, "two.pid")
+	for _, name := range []string{one, two} {
+		if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	uid, gid := os.Getuid()+1, os.Getgid()
+	if err := handOverDir(shared, []string{one}, uid, gid); err == nil || !strings.Contains(err.Error(), "two.pid") {
+		t.Errorf("handOverDir() of the directory shared with two.pid = %v, want an error naming it", err)
+	}
+
+	if os.Geteuid() != 0 {
+		return
+	}
+	for _, app := range []string{"one", "two"} {
+		dir := filepath.Join(shared, app)
+		pidFile := filepath.Join(dir, app+".pid")
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(pidFile, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := handOverDir(dir, []string{pidFile}, uid, gid); err != nil {
+			t.Errorf("handOverDir() of the directory of %v = %v", app, err)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/kardianos/osext"
+)
+
+// Service types accepted by "<daemon> install-service --type".
+const (
+	ServiceSystemd = "systemd"
+	ServiceSysV    = "sysv"
+)
+
+// Locations the service files are installed to.
+const (
+	systemdUnitDir = "/etc/systemd/system"
+	sysvInitDir    = "/etc/init.d"
+)
+
+// serviceFuncs are the functions the service templates quote the global flags with.
+var serviceFuncs = template.FuncMap{
+	"systemdArg":     systemdArg,
+	"shellArg":       shellArg,
+	"inDoubleQuotes": inDoubleQuotes,
+}
+
+// systemdUnit is the template for the generated systemd unit. The daemon runs in foreground mode so systemd tracks
+// the process itself, and is notified when the worker is ready. The global flags go before the command, and a Host
+// binary takes the app name after it.
+var systemdUnit = template.Must(template.New("systemd").Funcs(serviceFuncs).Parse(`[Unit]
+Description={{.AppName}} daemon
+After=network.target
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart={{.Executable}}{{range .Flags}} {{systemdArg .}}{{end}} foreground{{with .App}} {{.}}{{end}}
+ExecReload={{.Executable}}{{range .Flags}} {{systemdArg .}}{{end}} reload{{with .App}} {{.}}{{end}}
+{{- if .PidFile}}
+PIDFile={{.PidFile}}
+{{- end}}
+{{- if .WorkingDir}}
+WorkingDirectory={{.WorkingDir}}
+{{- end}}
+{{- if .User}}
+User={{.User}}
+{{- end}}
+{{- if .EnvFile}}
+EnvironmentFile={{.EnvFile}}
+{{- end}}
+{{- if .LogFile}}
+StandardOutput=append:{{.LogFile}}
+StandardError=append:{{.LogFile}}
+{{- end}}
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// sysvScript is the template for the generated LSB init script, for hosts without systemd. The daemon's own CLI does
+// the work, run as the configured user, with the global flags the script was installed with. Under su the command is
+// a string read by a second shell, so the flags are quoted twice.
+var sysvScript = template.Must(template.New("sysv").Funcs(serviceFuncs).Parse(`#!/bin/sh
+### BEGIN INIT INFO
+# Provides:          {{.AppName}}
+# Required-Start:    $remote_fs $syslog
+# Required-Stop:     $remote_fs $syslog
+# Default-Start:     2 3 4 5
+# Default-Stop:      0 1 6
+# Short-Description: {{.AppName}} daemon
+### END INIT INFO
+
+# PID file:          {{.PidFile}}
+# Log file:          {{.LogFile}}
+# Working directory: {{.WorkingDir}}
+
+DAEMON="{{.Executable}}"
+APP="{{.App}}"
+RUN_AS="{{.User}}"
+
+run() {
+    if [ -n "$RUN_AS" ] && [ "$(id -un)" != "$RUN_AS" ]; then
+        su -s /bin/sh -c "\"$DAEMON\"{{range .Flags}} {{shellArg . | inDoubleQuotes}}{{end}} $1 $APP" "$RUN_AS"
+    else
+        "$DAEMON"{{range .Flags}} {{shellArg .}}{{end}} "$1" $APP
+    fi
+}
+
+case "$1" in
+    start|stop|restart|reload|status)
+        run "$1"
+        ;;
+    force-reload)
+        run reload
+        ;;
+    *)
+        echo "Usage: $0 {start|stop|restart|reload|force-reload|status}"
+        exit 2
+        ;;
+esac
+exit $?
+`))
+
+// serviceInfo is the data the service templates are rendered from.
+type serviceInfo struct {
+	AppName string
+	// App is the app name passed to a Host binary after the command, empty for a single daemon binary.
+	App        string
+	Executable string
+	// Flags are the global flags passed to the daemon before the command.
+	Flags      []string
+	PidFile    string
+	LogFile    string
+	WorkingDir string
+	User       string
+	EnvFile    string
+}
+
+// detectServiceType returns the type of service file suited to this host: systemd when it is the running init system,
+// otherwise an LSB init script.
+func detectServiceType() string {
+	if fi, err := os.Stat("/run/systemd/system"); err == nil && fi.IsDir() {
+		return ServiceSystemd
+	}
+	return ServiceSysV
+}
+
+// serviceFile returns the pathname and template of the service file of the given type for the daemon.
+func serviceFile(ctx *Context, serviceType string) (string, *template.Template, error) {
+	appName := ctx.goctx.Args[0]
+	switch serviceType {
+	case ServiceSystemd:
+		return filepath.Join(systemdUnitDir, appName+".service"), systemdUnit, nil
+	case ServiceSysV:
+		return filepath.Join(sysvInitDir, appName), sysvScript, nil
+	default:
+		return "", nil, fmt.Errorf("unknown service type %q, expected %v or %v", serviceType, ServiceSystemd,
+			ServiceSysV)
+	}
+}
+
+// installService is the function used by the parent process to write a systemd unit or LSB init script for the
+// daemon, built from its context. The file runs the daemon as user, or root when user is empty. When dryRun is true
+// the file is printed instead.
+func installService(ctx *Context, serviceType string, user string, dryRun bool) error {
+	path, tmpl, err := serviceFile(ctx, serviceType)
+	if err != nil {
+		return err
+	}
+	executable, err := osext.Executable()
+	if err != nil {
+		return err
+	}
+	flags, err := serviceFlags(ctx.options)
+	if err != nil {
+		return err
+	}
+
+	info := serviceInfo{
+		AppName:    ctx.goctx.Args[0],
+		Executable: executable,
+		Flags:      flags,
+		PidFile:    ctx.goctx.PidFileName,
+		LogFile:    ctx.goctx.LogFileName,
+		WorkingDir: ctx.goctx.WorkDir,
+		User:       user,
+	}
+	if ctx.hosted {
+		info.App = info.AppName
+	}
+	if ctx.environment != nil {
+		info.EnvFile = ctx.environment.File
+	}
+	var content bytes.Buffer
+	if err = tmpl.Execute(&content, info); err != nil {
+		return err
+	}
+
+	if dryRun {
+		fmt.Printf("# %v\n%v", path, content.String())
+		return nil
+	}
+
+	mode := os.FileMode(0644)
+	if serviceType == ServiceSysV {
+		mode = 0755
+	}
+	if err = ioutil.WriteFile(path, content.Bytes(), mode); err != nil {
+		return err
+	}
+	fmt.Println("Installed", path)
+	if serviceType == ServiceSystemd {
+		fmt.Printf("Run 'systemctl daemon-reload && systemctl enable --now %v' to start it\n", info.AppName)
+	} else {
+		fmt.Printf("Run 'update-rc.d %[1]v defaults' or 'chkconfig --add %[1]v' to enable it\n", info.AppName)
+	}
+	return nil
+}
+
+// serviceFlags returns the global flags of options as the service file passes them to the daemon, so the service runs
+// with the configuration file, PID file and settings "install-service" was given. The files are made absolute, as
+// the service doesn't start in the current directory.
+func serviceFlags(options Options) ([]string, error) {
+	for _, name := range []*string{&options.ConfigFile, &options.PidFile} {
+		if *name == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*name)
+		if err != nil {
+			return nil, err
+		}
+		*name = abs
+	}
+	return options.args(), nil
+}
+
+// systemdArg returns arg quoted for a systemd command line: '%' specifiers and '$' variables are escaped, and an
+// argument holding spaces, quotes or backslashes is double quoted.
+func systemdArg(arg string) string {
+	arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\"'\\;") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(arg) + `"`
+}
+
+// shellArg returns arg single quoted for a POSIX shell.
+func shellArg(arg string) string {
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// inDoubleQuotes returns s escaped to be put inside a double quoted shell string.
+func inDoubleQuotes(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(s)
+}
+
+// uninstallService is the function used by the parent process to remove the service file written by installService.
+// When dryRun is true the file is left in place.
+func uninstallService(ctx *Context, serviceType string, dryRun bool) error {
+	path, _, err := serviceFile(ctx, serviceType)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Println("Would remove", path)
+		return nil
+	}
+	if err = os.Remove(path); err != nil {
+		return err
+	}
+	fmt.Println("Removed", path)
+	return nil
+}
@@ -0,0 +1,113 @@
+package daemon
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// serviceTestFlags are global flags that need quoting in both service files.
+var serviceTestFlags = Options{
+	ConfigFile: "/etc/my app/it's.yaml",
+	PidFile:    "/run/app.pid",
+	Settings:   []string{`message=say "$HOME" 100% \ok`, "tags=`id`"},
+}
+
+// TestSystemdUnitFlags checks that the global flags given to install-service reach the daemon started and reloaded by
+// the systemd unit, quoted for systemd.
+func TestSystemdUnitFlags(t *testing.T) {
+	flags, err := serviceFlags(serviceTestFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content bytes.Buffer
+	err = systemdUnit.Execute(&content, serviceInfo{AppName: "app", App: "app", Executable: "/usr/bin/host",
+		Flags: flags})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := `"/etc/my app/it's.yaml" --pidfile /run/app.pid --set "message=say \"$$HOME\" 100%% \\ok" --set tags=` +
+		"`id`"
+	for _, want := range []string{
+		"ExecStart=/usr/bin/host --config " + args + " foreground app\n",
+		"ExecReload=/usr/bin/host --config " + args + " reload app\n",
+	} {
+		if !strings.Contains(content.String(), want) {
+			t.Errorf("the unit has no %q:\n%v", want, content.String())
+		}
+	}
+}
+
+// TestSysVScriptFlags checks that the global flags given to install-service reach the daemon run by the init script
+// unchanged, directly and by way of su.
+func TestSysVScriptFlags(t *testing.T) {
+	// The directory has to be reachable by the user the script runs the daemon as.
+	dir, err := ioutil.TempDir("", "daemon-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//noinspection GoUnhandledErrorResult
+	defer os.RemoveAll(dir)
+	if err = os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The daemon prints its arguments, one per line.
+	executable := filepath.Join(dir, "daemon")
+	if err = ioutil.WriteFile(executable, []byte("#!/bin/sh\nfor arg; do printf '%s\\n' \"$arg\"; done\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	flags, err := serviceFlags(serviceTestFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(flags, "status", "app")
+	users := []string{""}
+	if nobody, err := user.Lookup("nobody"); err == nil && os.Geteuid() == 0 {
+		users = append(users, nobody.Username)
+	}
+	for _, runAs := range users {
+		var content bytes.Buffer
+		err = sysvScript.Execute(&content, serviceInfo{AppName: "app", App: "app", Executable: executable,
+			Flags: flags, User: runAs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		script := filepath.Join(dir, "init")
+		if err = ioutil.WriteFile(script, content.Bytes(), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := exec.Command("/bin/sh", script, "status").CombinedOutput()
+		if err != nil {
+			t.Fatalf("the script run as %q failed, %v: %s", runAs, err, out)
+		}
+		if got := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"); !reflect.DeepEqual(got, want) {
+			t.Errorf("the script run as %q passed %q, want %q", runAs, got, want)
+		}
+	}
+}
+
+// TestServiceFlagsAbsolute checks that the files named by the global flags are made absolute for the service.
+func TestServiceFlagsAbsolute(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags, err := serviceFlags(Options{ConfigFile: "app.yaml", PidFile: "run/app.pid", LogLevel: "debug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"--config", filepath.Join(dir, "app.yaml"), "--pidfile", filepath.Join(dir, "run/app.pid"),
+		"--log-level", "debug"}
+	if !reflect.DeepEqual(flags, want) {
+		t.Errorf("serviceFlags() = %q, want %q", flags, want)
+	}
+}
@@ -0,0 +1,141 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// AddSignalHandler is an optional method used to register the function called when the running daemon receives sig.
+// It replaces the built-in handlers: SIGUSR1 reopens the daemon's log files, and SIGUSR2 and SIGQUIT write a
+// goroutine dump and runtime statistics to a file in the log directory. SIGTERM and SIGHUP cannot be replaced, see
+// SetTerminatorHandler() and SetReloadHandler().
+//
+// NOTE: an error returned by f stops the daemon, as for the handler set with SetTerminatorHandler(); return nil to
+// keep the daemon running.
+func (ctx *Context) AddSignalHandler(sig os.Signal, f HandlerFunc) {
+	if ctx.signalHandlers == nil {
+		ctx.signalHandlers = make(map[os.Signal]HandlerFunc)
+	}
+	ctx.signalHandlers[sig] = f
+}
+
+// signalHandlerMap returns the handlers for the signals other than SIGTERM and SIGHUP: the built-in ones, replaced by
+// those registered with AddSignalHandler().
+func (ctx *Context) signalHandlerMap() map[os.Signal]HandlerFunc {
+	handlers := map[os.Signal]HandlerFunc{
+		syscall.SIGUSR1: func(_ os.Signal) error {
+			if err := ctx.reopenLogs(); err != nil {
+				ctx.state.logf(logrus.ErrorLevel, "Cannot reopen the log files, %v", err)
+			} else {
+				ctx.state.logf(logrus.InfoLevel, "Reopened the log files")
+			}
+			return nil
+		},
+		syscall.SIGUSR2: ctx.dumpDiagnostics,
+		syscall.SIGQUIT: ctx.dumpDiagnostics,
+	}
+	for sig, f := range ctx.signalHandlers {
+		if sig != syscall.SIGTERM && sig != syscall.SIGHUP {
+			handlers[sig] = f
+		}
+	}
+	return handlers
+}
+
+// reopenLogs reopens the daemon's log files by name, so they can be rotated by an external tool such as logrotate. The
+// new file is put in place of the old one with dup2, so *os.File values held elsewhere stay valid. The log file that
+// go-daemon connects to stdout and stderr is reopened, and so is the output of the logger handed over with
+// SetLogger() when it is a file, e.g. the one opened by logutil.SetupLogging.
+//
+// NOTE: godaemon.WasReborn() is false in a daemon process started by a graceful restart, so whether stdout and stderr
+// are the log file is recorded in the context instead.
+func (ctx *Context) reopenLogs() error {
+	if ctx.logOnStdout {
+		if err := reopenFile(ctx.goctx.LogFileName, os.Stdout, os.Stderr); err != nil {
+			return err
+		}
+	}
+	if logger := ctx.state.getLogger(); logger != nil {
+		if file, ok := logger.Out.(*os.File); ok && file != os.Stdout && file != os.Stderr {
+			return reopenFile(file.Name(), file)
+		}
+	}
+	return nil
+}
+
+// reopenFile opens name for appending, creating it if it's gone, and puts it in place of each of files.
+func reopenFile(name string, files ...*os.File) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer file.Close()
+
+	for _, old := range files {
+		if err = unix.Dup2(int(file.Fd()), int(old.Fd())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpDiagnostics is the built-in SIGUSR2 and SIGQUIT handler. It writes the runtime statistics, the daemon status and
+// a dump of all goroutines to a timestamped file in the log directory, and the daemon carries on running.
+func (ctx *Context) dumpDiagnostics(sig os.Signal) error {
+	name, err := ctx.writeDiagnostics(sig)
+	if err != nil {
+		ctx.state.logf(logrus.ErrorLevel, "Cannot write diagnostics, %v", err)
+	} else {
+		ctx.state.logf(logrus.InfoLevel, "Wrote diagnostics to %v", name)
+	}
+	return nil
+}
+
+// writeDiagnostics writes the diagnostics file, and returns its name.
+func (ctx *Context) writeDiagnostics(sig os.Signal) (string, error) {
+	dir := os.TempDir()
+	if ctx.goctx.LogFileName != "" {
+		dir = filepath.Dir(ctx.goctx.LogFileName)
+	}
+	now := time.Now().UTC()
+	name := filepath.Join(dir, fmt.Sprintf("%v-diagnostics-%v.txt", ctx.goctx.Args[0], now.Format("20060102T150405.000Z")))
+
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return "", err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer file.Close()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	status, _ := json.MarshalIndent(ctx.status(), "", "  ")
+
+	sigName := sig.String()
+	if s, ok := sig.(syscall.Signal); ok {
+		sigName = unix.SignalName(s)
+	}
+	fmt.Fprintf(file, "Diagnostics for %v, PID %v, at %v on %v\n\n", ctx.goctx.Args[0], os.Getpid(),
+		now.Format(time.RFC3339), sigName)
+	fmt.Fprintf(file, "Go version:     %v\n", runtime.Version())
+	fmt.Fprintf(file, "CPUs:           %v, GOMAXPROCS %v\n", runtime.NumCPU(), runtime.GOMAXPROCS(0))
+	fmt.Fprintf(file, "Goroutines:     %v\n", runtime.NumGoroutine())
+	fmt.Fprintf(file, "Heap:           %v bytes in use, %v objects\n", mem.HeapAlloc, mem.HeapObjects)
+	fmt.Fprintf(file, "Memory:         %v bytes from the OS, %v bytes allocated in total\n", mem.Sys, mem.TotalAlloc)
+	fmt.Fprintf(file, "GC:             %v runs, %v paused in total\n\n", mem.NumGC, time.Duration(mem.PauseTotalNs))
+	fmt.Fprintf(file, "Status:\n%s\n\nGoroutines:\n", status)
+	if err = pprof.Lookup("goroutine").WriteTo(file, 2); err != nil {
+		return "", err
+	}
+	return name, file.Sync()
+}
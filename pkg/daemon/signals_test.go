@@ -0,0 +1,98 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestReopenLogs checks that SIGUSR1 makes the daemon write to a new log file once the old one has been renamed, as
+// logrotate does.
+func TestReopenLogs(t *testing.T) {
+	dir := t.TempDir()
+	ctx := startTestDaemon(t, dir, "")
+	name := filepath.Join(dir, "log", "test.log")
+	if err := os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+
+	signalTestDaemon(t, ctx, syscall.SIGUSR1)
+	waitForFile(t, name, "Reopened the log files")
+	if data, err := ioutil.ReadFile(name + ".1"); err != nil || strings.Contains(string(data), "Reopened") {
+		t.Errorf("the daemon wrote to the rotated log file, %v", err)
+	}
+}
+
+// TestDumpDiagnostics checks that SIGUSR2 writes a timestamped diagnostics file to the log directory, and that the
+// daemon carries on running.
+func TestDumpDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	ctx := startTestDaemon(t, dir, "")
+
+	signalTestDaemon(t, ctx, syscall.SIGUSR2)
+	waitForFile(t, filepath.Join(dir, "log", "test.log"), "Wrote diagnostics to")
+	names, err := filepath.Glob(filepath.Join(dir, "log", "test-diagnostics-*.txt"))
+	if err != nil || len(names) != 1 {
+		t.Fatalf("diagnostics files %q, want one, %v", names, err)
+	}
+	data, err := ioutil.ReadFile(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Diagnostics for test", "on SIGUSR2", "Goroutines:", `"app_name": "test"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("the diagnostics file has no %q", want)
+		}
+	}
+	if _, err = ctx.Status(); err != nil {
+		t.Errorf("Status() after SIGUSR2 = %v, want the daemon running", err)
+	}
+}
+
+// TestAddSignalHandler checks that a handler added with AddSignalHandler replaces the built-in one.
+func TestAddSignalHandler(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(testModeEnv, "handler")
+	ctx := startTestDaemon(t, dir, "")
+
+	signalTestDaemon(t, ctx, syscall.SIGUSR2)
+	waitForFile(t, filepath.Join(dir, "SIGUSR2"), "")
+	if names, _ := filepath.Glob(filepath.Join(dir, "log", "test-diagnostics-*.txt")); len(names) != 0 {
+		t.Errorf("the built-in handler wrote %q", names)
+	}
+	if _, err := ctx.Status(); err != nil {
+		t.Errorf("Status() after SIGUSR2 = %v, want the daemon running", err)
+	}
+}
+
+// signalTestDaemon sends sig to the daemon process of the running test daemon.
+func signalTestDaemon(t *testing.T, ctx *Context, sig syscall.Signal) {
+	t.Helper()
+	status, err := ctx.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = syscall.Kill(status.Pid, sig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitForFile waits for the file name to exist and hold text.
+func waitForFile(t *testing.T, name string, text string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		data, err := ioutil.ReadFile(name)
+		if err == nil && strings.Contains(string(data), text) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v doesn't hold %q after 10s, %v", name, text, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
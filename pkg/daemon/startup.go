@@ -0,0 +1,162 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultStartTimeout is how long "<daemon> start", and a graceful restart, wait for the new daemon process to report
+// that it is ready, when SetStartTimeout has not been called.
+const DefaultStartTimeout = 30 * time.Second
+
+// startupEnv is the environment variable that names the socket a daemon process started by "<daemon> start" reports
+// its start-up on.
+const startupEnv = "GO_DAEMONS_STARTUP"
+
+// exitReportWait is how long the parent process still waits for the start-up report of a daemon process that has
+// exited, as the report can arrive after the exit has been noticed.
+const exitReportWait = 1 * time.Second
+
+// SetStartTimeout is an optional method used to set how long "<daemon> start", Start and a graceful restart wait for
+// the new daemon process to report that it is ready. If not provided DefaultStartTimeout is used.
+func (ctx *Context) SetStartTimeout(d time.Duration) {
+	ctx.startTimeout = d
+}
+
+// getStartTimeout returns the start timeout for the daemon, falling back to the default.
+func (ctx *Context) getStartTimeout() time.Duration {
+	if ctx.startTimeout == 0 {
+		return DefaultStartTimeout
+	}
+	return ctx.startTimeout
+}
+
+// startupListener is the parent process end of the start-up handshake. go-daemon only passes its own files on to the
+// daemon process, so rather than a pipe the daemon process is given the name of a datagram socket, in a directory
+// only the parent's user can reach, in $GO_DAEMONS_STARTUP. The daemon process connects to it before it drops its
+// privileges, and sends "READY=1" once the worker is ready, or "ERROR=<message>" if it fails first.
+type startupListener struct {
+	conn *net.UnixConn
+	dir  string
+	path string
+}
+
+// listenStartup returns a new startupListener.
+func listenStartup() (*startupListener, error) {
+	dir, err := ioutil.TempDir("", "godaemon-startup-")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "startup.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	return &startupListener{conn: conn, dir: dir, path: path}, nil
+}
+
+// Close closes the socket and removes its directory.
+func (l *startupListener) Close() error {
+	err := l.conn.Close()
+	_ = os.RemoveAll(l.dir)
+	return err
+}
+
+// env returns the environment for the daemon process: base with the socket's name added.
+func (l *startupListener) env(base []string) []string {
+	var env []string
+	for _, kv := range base {
+		if !strings.HasPrefix(kv, startupEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	return append(env, fmt.Sprintf("%v=%v", startupEnv, l.path))
+}
+
+// wait waits up to timeout for the daemon process to report its start-up, and returns nil if it is ready. The error
+// the daemon process reported is returned if it failed, and an error saying so if it exits, or the timeout passes,
+// before reporting anything. The exited channel receives the exit status of the daemon process.
+func (l *startupListener) wait(timeout time.Duration, exited <-chan string) error {
+	reports := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		n, err := l.conn.Read(buf)
+		if err == nil {
+			reports <- string(buf[:n])
+		}
+	}()
+
+	var report string
+	select {
+	case report = <-reports:
+	case status := <-exited:
+		select {
+		case report = <-reports:
+		case <-time.After(exitReportWait):
+			return fmt.Errorf("the daemon process exited before it was ready, %v", status)
+		}
+	case <-time.After(timeout):
+		return fmt.Errorf("the daemon process was not ready after %v", timeout)
+	}
+
+	if strings.HasPrefix(report, "ERROR=") {
+		return fmt.Errorf("%v", strings.TrimPrefix(report, "ERROR="))
+	}
+	return nil
+}
+
+// startupReporter is the daemon process end of the start-up handshake. A nil startupReporter is valid, and silently
+// drops the report, as for a daemon process that wasn't started by "<daemon> start".
+type startupReporter struct {
+	conn *net.UnixConn
+}
+
+// dialStartup connects to the socket named by $GO_DAEMONS_STARTUP, and returns nil when it isn't set or can't be
+// reached. The variable is removed, so processes started by the daemon don't inherit it.
+func dialStartup() *startupReporter {
+	path := os.Getenv(startupEnv)
+	if path == "" {
+		return nil
+	}
+	_ = os.Unsetenv(startupEnv)
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil
+	}
+	return &startupReporter{conn: conn}
+}
+
+// report sends the outcome of the start-up, ready when err is nil, to the parent process. Only the first report is
+// sent.
+func (r *startupReporter) report(err error) {
+	if r == nil || r.conn == nil {
+		return
+	}
+	msg := "READY=1"
+	if err != nil {
+		msg = "ERROR=" + err.Error()
+	}
+	_, _ = r.conn.Write([]byte(msg))
+	_ = r.conn.Close()
+	r.conn = nil
+}
+
+// startupFailed reports err to the parent process waiting for the daemon to start, if the worker hasn't reported
+// that it is ready.
+func (state *runState) startupFailed(err error) {
+	if state == nil {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if !state.isReady {
+		state.startup.report(err)
+	}
+}
@@ -0,0 +1,139 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStartupHandshake checks the outcome of the start-up reported over the socket named by $GO_DAEMONS_STARTUP.
+func TestStartupHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		report  func(r *startupReporter)
+		exited  string
+		timeout time.Duration
+		err     string
+	}{
+		{"ready", func(r *startupReporter) { r.report(nil) }, "", time.Second, ""},
+		{"ready then exited", func(r *startupReporter) { r.report(nil) }, "exit status 0", time.Second, ""},
+		{"error", func(r *startupReporter) { r.report(errors.New("invalid configuration, bad port")) }, "",
+			time.Second, "invalid configuration, bad port"},
+		{"first report only", func(r *startupReporter) {
+			r.report(errors.New("cannot listen"))
+			r.report(nil)
+		}, "", time.Second, "cannot listen"},
+		{"timeout", nil, "", 50 * time.Millisecond, "the daemon process was not ready after 50ms"},
+		{"exited without a report", nil, "exit status 3", time.Minute,
+			"the daemon process exited before it was ready, exit status 3"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener, err := listenStartup()
+			if err != nil {
+				t.Fatal(err)
+			}
+			//noinspection GoUnhandledErrorResult
+			defer listener.Close()
+
+			t.Setenv(startupEnv, listener.path)
+			reporter := dialStartup()
+			if reporter == nil {
+				t.Fatalf("dialStartup() = nil, want a reporter for %v", listener.path)
+			}
+			if path, ok := os.LookupEnv(startupEnv); ok {
+				t.Errorf("$%v = %v after dialStartup(), want it removed", startupEnv, path)
+			}
+			if test.report != nil {
+				test.report(reporter)
+			}
+			exited := make(chan string, 1)
+			if test.exited != "" {
+				exited <- test.exited
+			}
+
+			err = listener.wait(test.timeout, exited)
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("wait() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.err {
+				t.Errorf("wait() = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+// TestStartupWithoutListener checks that a daemon process not started by "<daemon> start" has no reporter, and that
+// reporting to it does nothing.
+func TestStartupWithoutListener(t *testing.T) {
+	t.Setenv(startupEnv, "")
+	reporter := dialStartup()
+	if reporter != nil {
+		t.Errorf("dialStartup() = %+v without $%v, want nil", reporter, startupEnv)
+	}
+	reporter.report(nil)
+
+	var state *runState
+	state.startupFailed(errors.New("ignored"))
+}
+
+// TestStartupEnv checks that the daemon process is given the socket of this start, and not an inherited one.
+func TestStartupEnv(t *testing.T) {
+	listener := &startupListener{path: "/tmp/new/startup.sock"}
+	env := listener.env([]string{"PATH=/bin", startupEnv + "=/tmp/old/startup.sock"})
+	want := []string{"PATH=/bin", startupEnv + "=/tmp/new/startup.sock"}
+	if strings.Join(env, " ") != strings.Join(want, " ") {
+		t.Errorf("env() = %q, want %q", env, want)
+	}
+}
+
+// TestStartWaitsForReady checks that "start" only succeeds once the daemon process is ready, and otherwise fails with
+// the reason the daemon process gave, or the reason it never reported.
+func TestStartWaitsForReady(t *testing.T) {
+	tests := []struct {
+		mode    string
+		timeout time.Duration
+		err     string
+		status  error
+	}{
+		{"fail", 10 * time.Second, errTestStartup.Error(), ErrNotRunning},
+		// The daemon process exits without releasing its PID file, as after a crash.
+		{"exit", 10 * time.Second, "the daemon process exited before it was ready, exit status 3", ErrStalePidFile},
+		{"hang", time.Second, "the daemon process was not ready after 1s", ErrNotRunning},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv(testDaemonEnv, dir)
+			t.Setenv(testModeEnv, test.mode)
+			ctx := newTestDaemon(dir, "")
+			ctx.SetStartTimeout(test.timeout)
+
+			var code int
+			output := captureStdout(t, func() {
+				code = runCommandLine(ctx, []string{"start"})
+			})
+			if ExitCode(code) != ExitFailure {
+				t.Errorf("start exit code %d, want %d", code, ExitFailure)
+			}
+			if want := "cannot start the daemon test, " + test.err; !strings.Contains(output, want) {
+				t.Errorf("start wrote %q, want %q", output, want)
+			}
+			if _, err := ctx.Status(); err != test.status {
+				t.Errorf("Status() after the failed start = %v, want %v", err, test.status)
+			}
+		})
+	}
+
+	// The daemon process is ready straight away in the default mode, so start succeeds.
+	dir := t.TempDir()
+	ctx := startTestDaemon(t, dir, "")
+	if _, err := ctx.Status(); err != nil {
+		t.Errorf("Status() after start = %v, want the daemon running", err)
+	}
+}
@@ -0,0 +1,299 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// clockTicks is the kernel USER_HZ used for the CPU times in /proc/<pid>/stat. It is 100 on every Linux platform
+// we run on, and reading the real value needs cgo.
+const clockTicks = 100
+
+// stateKey is the context.Context key under which the daemon's runState is stored for run functions.
+type stateKey struct{}
+
+// RunRecord describes a single orchestration run reported with RecordRun.
+type RunRecord struct {
+	Time            time.Time `json:"time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	OK              bool      `json:"ok"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// ProcessResources is the resource usage of the daemon process as read from /proc/<pid>. OpenFDs is -1 when the
+// caller isn't allowed to read /proc/<pid>/fd.
+type ProcessResources struct {
+	RSSBytes   int64   `json:"rss_bytes"`
+	CPUSeconds float64 `json:"cpu_seconds"`
+	OpenFDs    int     `json:"open_fds"`
+	Threads    int     `json:"threads"`
+}
+
+// Status is the information reported by "<daemon> status". Fields the parent process couldn't find out, for example
+// because the control socket could not be reached, are left empty.
+type Status struct {
+	AppName             string            `json:"app_name"`
+	Pid                 int               `json:"pid"`
+	Version             string            `json:"version,omitempty"`
+	StartTime           *time.Time        `json:"start_time,omitempty"`
+	UptimeSeconds       float64           `json:"uptime_seconds,omitempty"`
+	LastRun             *RunRecord        `json:"last_run,omitempty"`
+	Heartbeat           *time.Time        `json:"heartbeat,omitempty"`
+	HeartbeatAgeSeconds float64           `json:"heartbeat_age_seconds,omitempty"`
+	Panics              int               `json:"panics,omitempty"`
+	Crashes             int               `json:"crashes,omitempty"`
+	LastCrash           *Crash            `json:"last_crash,omitempty"`
+	Resources           *ProcessResources `json:"resources,omitempty"`
+	Identity            *ProcessIdentity  `json:"identity,omitempty"`
+	Limits              *ProcessLimits    `json:"limits,omitempty"`
+	ControlSocket       string            `json:"control_socket,omitempty"`
+}
+
+// runState is the run-time information the daemon process keeps about itself for the status command.
+type runState struct {
+	mu         sync.Mutex
+	started    time.Time
+	lastRun    *RunRecord
+	heartbeat  time.Time
+	crashCount int
+	crashes    []Crash
+	panicCount int
+	// panicPolicy and logger are used by Guard() and protect() when a panic is recovered.
+	panicPolicy PanicPolicy
+	logger      *logrus.Logger
+	// notifier, isReady and heartbeatAdvanced drive the service manager notifications.
+	notifier          *notifier
+	isReady           bool
+	heartbeatAdvanced time.Time
+	// listeners and upgrade carry the sockets across a graceful restart.
+	listeners []managedListener
+	upgrade   *upgradeState
+	// startup reports the outcome of the start-up to the parent process.
+	startup *startupReporter
+}
+
+// SetVersion is an optional method used to set the version of the daemon binary reported by "<daemon> status".
+func (ctx *Context) SetVersion(version string) {
+	ctx.version = version
+}
+
+// RecordRun is called by a run function after each orchestration run, to make the time and result of the last run
+// available to "<daemon> status". The ctx parameter is the context passed to the run function. It is a noop for any
+// other context.
+func RecordRun(ctx context.Context, started time.Time, err error) {
+	state, ok := ctx.Value(stateKey{}).(*runState)
+	if !ok {
+		return
+	}
+
+	record := &RunRecord{Time: started, DurationSeconds: time.Since(started).Seconds(), OK: err == nil}
+	result := "ok"
+	if err != nil {
+		record.Error = err.Error()
+		result = "failed, " + record.Error
+	}
+
+	state.mu.Lock()
+	state.lastRun = record
+	n := state.notifier
+	state.mu.Unlock()
+
+	_ = n.notify(fmt.Sprintf("STATUS=Last run at %v, %v", started.Format(time.RFC3339), result))
+}
+
+// RecordHeartbeat is called by a run function to report the daemon's latest heartbeat, which "<daemon> status"
+// displays as the heartbeat age. The ctx parameter is the context passed to the run function.
+func RecordHeartbeat(ctx context.Context, t time.Time) {
+	state, ok := ctx.Value(stateKey{}).(*runState)
+	if !ok {
+		return
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if !t.Equal(state.heartbeat) {
+		state.heartbeat = t
+		state.heartbeatAdvanced = time.Now().UTC()
+	}
+}
+
+// getLogger returns the logger handed over with SetLogger(), or nil if there is none.
+func (state *runState) getLogger() *logrus.Logger {
+	if state == nil {
+		return nil
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.logger
+}
+
+// logf writes a message to the logger handed over with SetLogger(), or the standard logger when there is none.
+func (state *runState) logf(level logrus.Level, format string, args ...interface{}) {
+	if logger := state.getLogger(); logger != nil {
+		logger.Logf(level, format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// newRunState allocates the run-time state for the daemon process, and returns runCtx with the state attached.
+func newRunState(ctx *Context, runCtx context.Context) context.Context {
+	now := time.Now().UTC()
+	ctx.state = &runState{started: now, heartbeatAdvanced: now, panicPolicy: ctx.getPanicPolicy(), upgrade: ctx.upgrade}
+	return context.WithValue(runCtx, stateKey{}, ctx.state)
+}
+
+// status is the function used by the daemon process to answer the "status" control command.
+func (ctx *Context) status() Status {
+	status := Status{AppName: ctx.goctx.Args[0], Pid: os.Getpid(), Version: ctx.version, Identity: currentIdentity(),
+		Limits: ctx.currentLimits()}
+	if ctx.state == nil {
+		return status
+	}
+
+	ctx.state.mu.Lock()
+	defer ctx.state.mu.Unlock()
+	started := ctx.state.started
+	status.StartTime = &started
+	if ctx.state.lastRun != nil {
+		lastRun := *ctx.state.lastRun
+		status.LastRun = &lastRun
+	}
+	if !ctx.state.heartbeat.IsZero() {
+		heartbeat := ctx.state.heartbeat
+		status.Heartbeat = &heartbeat
+	}
+	status.Panics = ctx.state.panicCount
+	status.Crashes = ctx.state.crashCount
+	if n := len(ctx.state.crashes); n > 0 {
+		lastCrash := ctx.state.crashes[n-1]
+		status.LastCrash = &lastCrash
+	}
+	return status
+}
+
+// readProcResources reads the resource usage of the process pid from /proc. Returns an error on systems without
+// procfs, or when the caller isn't allowed to look at the process.
+func readProcResources(pid int) (*ProcessResources, error) {
+	fields, err := readProcStat(pid)
+	if err != nil {
+		return nil, err
+	}
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	threads, _ := strconv.Atoi(fields[17])
+	rss, _ := strconv.ParseInt(fields[21], 10, 64)
+
+	resources := &ProcessResources{
+		RSSBytes:   rss * int64(os.Getpagesize()),
+		CPUSeconds: float64(utime+stime) / clockTicks,
+		Threads:    threads,
+		OpenFDs:    -1,
+	}
+	if fds, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/fd", pid)); err == nil {
+		resources.OpenFDs = len(fds)
+	}
+	return resources, nil
+}
+
+// queryStatus is the function used by the parent process to find out about the running daemon. The daemon is asked
+// over its control socket, falling back to what is known from the PID file, and the resource usage is read from
+// /proc.
+func queryStatus(ctx *Context, proc *os.Process) Status {
+	status := Status{AppName: ctx.goctx.Args[0], Pid: proc.Pid}
+	resp, err := callControl(ctx, "status", nil)
+	if err == nil && resp.OK && json.Unmarshal(resp.Result, &status) == nil {
+		status.ControlSocket = controlSocketPath(ctx)
+	}
+
+	now := time.Now().UTC()
+	if status.StartTime != nil {
+		status.UptimeSeconds = now.Sub(*status.StartTime).Seconds()
+	}
+	if status.Heartbeat != nil {
+		status.HeartbeatAgeSeconds = now.Sub(*status.Heartbeat).Seconds()
+	}
+	status.Resources, _ = readProcResources(status.Pid)
+	return status
+}
+
+// displayStatus is the function used by the parent process to display the status of the running daemon. When asJSON
+// is true the Status is printed as JSON.
+func displayStatus(status Status, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(status)
+	}
+	fmt.Print(status)
+	return nil
+}
+
+// seconds converts a number of seconds into a time.Duration rounded to the second, for display.
+func seconds(s float64) time.Duration {
+	return (time.Duration(s * float64(time.Second))).Round(time.Second)
+}
+
+// String returns the human readable, multi-line, form of the status.
+func (status Status) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v running as a daemon with PID %v\n", status.AppName, status.Pid)
+	if status.Version != "" {
+		fmt.Fprintf(&b, "  Version:        %v\n", status.Version)
+	}
+	if status.StartTime != nil {
+		fmt.Fprintf(&b, "  Started:        %v (up %v)\n", status.StartTime.Format(time.RFC3339),
+			seconds(status.UptimeSeconds))
+	}
+	if status.LastRun != nil {
+		result := "ok"
+		if !status.LastRun.OK {
+			result = "failed, " + status.LastRun.Error
+		}
+		fmt.Fprintf(&b, "  Last run:       %v (%v ago), %v\n", status.LastRun.Time.Format(time.RFC3339),
+			seconds(time.Since(status.LastRun.Time).Seconds()), result)
+	}
+	if status.Heartbeat != nil {
+		fmt.Fprintf(&b, "  Heartbeat:      %v (%v ago)\n", status.Heartbeat.Format(time.RFC3339),
+			seconds(status.HeartbeatAgeSeconds))
+	}
+	if status.Panics > 0 {
+		fmt.Fprintf(&b, "  Panics:         %v recovered\n", status.Panics)
+	}
+	if status.LastCrash != nil {
+		fmt.Fprintf(&b, "  Crashes:        %v, last at %v, %v\n", status.Crashes,
+			status.LastCrash.Time.Format(time.RFC3339), status.LastCrash.Reason)
+	}
+	if r := status.Resources; r != nil {
+		fds := "unknown"
+		if r.OpenFDs >= 0 {
+			fds = strconv.Itoa(r.OpenFDs)
+		}
+		fmt.Fprintf(&b, "  Resources:      RSS %.1f MiB, CPU %.2fs, %v open fds, %v threads\n",
+			float64(r.RSSBytes)/(1<<20), r.CPUSeconds, fds, r.Threads)
+	}
+	if id := status.Identity; id != nil {
+		caps := "no capabilities"
+		if len(id.Capabilities) > 0 {
+			caps = strings.Join(id.Capabilities, ", ")
+		}
+		fmt.Fprintf(&b, "  Running as:     %v(%v), group %v(%v), %v\n", id.User, id.UID, id.Group, id.GID, caps)
+	}
+	if status.Limits != nil {
+		fmt.Fprintf(&b, "  Limits:         %v\n", status.Limits)
+	}
+	if status.ControlSocket != "" {
+		fmt.Fprintf(&b, "  Control socket: %v\n", status.ControlSocket)
+	}
+	return b.String()
+}
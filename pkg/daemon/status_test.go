@@ -0,0 +1,124 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestReadProcResources checks the resource usage read from /proc for the test process itself.
+func TestReadProcResources(t *testing.T) {
+	// Burn some CPU time, so it can't round down to zero.
+	for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+	}
+
+	r, err := readProcResources(os.Getpid())
+	if err != nil {
+		t.Skipf("no procfs, %v", err)
+	}
+	if r.RSSBytes <= 0 || r.CPUSeconds <= 0 || r.OpenFDs <= 0 || r.Threads <= 0 {
+		t.Errorf("readProcResources() = %+v, want every value above zero", r)
+	}
+	if r.RSSBytes%int64(os.Getpagesize()) != 0 {
+		t.Errorf("readProcResources() RSS = %v, want a number of pages", r.RSSBytes)
+	}
+
+	if r, err = readProcResources(deadPid(t)); err == nil {
+		t.Errorf("readProcResources() of an exited process = %+v, want an error", r)
+	}
+}
+
+// testStatus returns a status with every field set.
+func testStatus() Status {
+	started := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	heartbeat := started.Add(time.Hour)
+	adj := 100
+	return Status{
+		AppName:             "test",
+		Pid:                 123,
+		Version:             "1.2.3",
+		StartTime:           &started,
+		UptimeSeconds:       7200,
+		LastRun:             &RunRecord{Time: heartbeat, DurationSeconds: 1.5, Error: "cannot connect"},
+		Heartbeat:           &heartbeat,
+		HeartbeatAgeSeconds: 30,
+		Panics:              2,
+		Crashes:             1,
+		LastCrash:           &Crash{Time: started.Add(time.Minute), Reason: "panic: boom", Stack: "goroutine 1"},
+		Resources:           &ProcessResources{RSSBytes: 3 << 20, CPUSeconds: 1.25, OpenFDs: 8, Threads: 5},
+		Identity: &ProcessIdentity{UID: 1000, User: "app", GID: 1000, Group: "app",
+			Capabilities: []string{"CAP_NET_BIND_SERVICE"}},
+		Limits: &ProcessLimits{Limits: map[string]ResourceLimit{"RLIMIT_NOFILE": {1024, 4096}}, Nice: 5,
+			IOClass: "idle", OOMScoreAdj: &adj, Cgroup: "/test"},
+		ControlSocket: "/run/test/test.sock",
+	}
+}
+
+// TestDisplayStatusJSON checks that "status --json" writes a Status that decodes back to itself.
+func TestDisplayStatusJSON(t *testing.T) {
+	for name, status := range map[string]Status{"full": testStatus(), "minimal": {AppName: "test", Pid: 123}} {
+		var err error
+		output := captureStdout(t, func() {
+			err = displayStatus(status, true)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Status
+		if err = json.Unmarshal([]byte(output), &decoded); err != nil {
+			t.Fatalf("%v: displayStatus() wrote invalid JSON, %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded, status) {
+			t.Errorf("%v: displayStatus() wrote %v, want %+v", name, output, status)
+		}
+	}
+}
+
+// TestStatusString checks the lines of the human readable status, and that the lines of the fields not set are left
+// out.
+func TestStatusString(t *testing.T) {
+	tests := []struct {
+		name   string
+		status Status
+		want   []string
+		absent []string
+	}{
+		{"full", testStatus(), []string{
+			"test running as a daemon with PID 123\n",
+			"  Version:        1.2.3\n",
+			"  Started:        2020-01-01T12:00:00Z (up 2h0m0s)\n",
+			"  Last run:       2020-01-01T13:00:00Z (",
+			"), failed, cannot connect\n",
+			"  Heartbeat:      2020-01-01T13:00:00Z (30s ago)\n",
+			"  Panics:         2 recovered\n",
+			"  Crashes:        1, last at 2020-01-01T12:01:00Z, panic: boom\n",
+			"  Resources:      RSS 3.0 MiB, CPU 1.25s, 8 open fds, 5 threads\n",
+			"  Running as:     app(1000), group app(1000), CAP_NET_BIND_SERVICE\n",
+			"  Limits:         NOFILE 1024/4096, nice 5, I/O idle, OOM score adj 100, cgroup /test\n",
+			"  Control socket: /run/test/test.sock\n",
+		}, nil},
+		{"minimal", Status{AppName: "test", Pid: 123}, []string{"test running as a daemon with PID 123\n"},
+			[]string{"Version", "Started", "Last run", "Heartbeat", "Panics", "Crashes", "Resources", "Running as",
+				"Limits", "Control socket"}},
+		{"unknown fds", Status{AppName: "test", Pid: 123, Resources: &ProcessResources{OpenFDs: -1},
+			Identity: &ProcessIdentity{User: "root", Group: "root"}},
+			[]string{"RSS 0.0 MiB, CPU 0.00s, unknown open fds, 0 threads\n",
+				"root(0), group root(0), no capabilities\n"}, nil},
+	}
+	for _, test := range tests {
+		s := test.status.String()
+		for _, want := range test.want {
+			if !strings.Contains(s, want) {
+				t.Errorf("%v: String() = %q, want %q", test.name, s, want)
+			}
+		}
+		for _, absent := range test.absent {
+			if strings.Contains(s, absent) {
+				t.Errorf("%v: String() = %q, want no %q", test.name, s, absent)
+			}
+		}
+	}
+}
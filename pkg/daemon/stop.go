@@ -0,0 +1,211 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Exit codes returned by the "stop" and "restart" commands when the daemon did not shut down on the first signal of
+// its StopPolicy. They are taken from the LSB range reserved for application use so they cannot be confused with a
+// general failure.
+const (
+	// ExitStopEscalated means the daemon only stopped after a later (escalated) step of the StopPolicy.
+	ExitStopEscalated ExitCode = 150
+	// ExitStopKilled means the daemon never stopped on its own and had to be sent a SIGKILL.
+	ExitStopKilled ExitCode = 151
+)
+
+// killWaitTime is how long to wait for the process to disappear after a SIGKILL has been sent.
+const killWaitTime = 5 * time.Second
+
+// ErrStopFailed is returned when the daemon is still running after every step of the StopPolicy has been tried.
+var ErrStopFailed = errors.New("daemon did not stop")
+
+// StopStep is a single step of a StopPolicy: the signal to send, and how long to wait for the daemon to exit before
+// moving on to the next step.
+type StopStep struct {
+	Signal syscall.Signal
+	Grace  time.Duration
+}
+
+// StopPolicy describes how the parent process escalates when asking a running daemon to shut down. Each step is tried
+// in order. If the daemon is still running after the last step, and AllowKill is true, it is sent a SIGKILL.
+type StopPolicy struct {
+	Steps     []StopStep
+	AllowKill bool
+}
+
+// DefaultStopPolicy returns the policy used when SetStopPolicy has not been called: SIGTERM, wait 10 seconds, SIGINT,
+// wait 10 seconds, then SIGKILL.
+func DefaultStopPolicy() StopPolicy {
+	return StopPolicy{
+		Steps: []StopStep{
+			{Signal: syscall.SIGTERM, Grace: 10 * time.Second},
+			{Signal: syscall.SIGINT, Grace: 10 * time.Second},
+		},
+		AllowKill: true,
+	}
+}
+
+// StopResult reports how a daemon was stopped.
+type StopResult struct {
+	// Pid is the process ID of the daemon that was stopped.
+	Pid int
+	// Step is the index into StopPolicy.Steps of the step that ended the process, or -1 if it was killed.
+	Step int
+	// Steps is the number of steps in the StopPolicy that was used.
+	Steps int
+	// Signal is the signal that ended the process.
+	Signal syscall.Signal
+	// Elapsed is the time taken from the first signal until the process went away.
+	Elapsed time.Duration
+}
+
+// Killed returns true if the daemon had to be sent a SIGKILL.
+func (r StopResult) Killed() bool {
+	return r.Step < 0
+}
+
+// ExitCode maps the result onto the exit code returned by the "stop" and "restart" commands.
+func (r StopResult) ExitCode() ExitCode {
+	switch {
+	case r.Killed():
+		return ExitStopKilled
+	case r.Step > 0:
+		return ExitStopEscalated
+	default:
+		return ExitSuccess
+	}
+}
+
+// String returns a human readable description of the result.
+func (r StopResult) String() string {
+	elapsed := r.Elapsed.Round(time.Millisecond)
+	if r.Killed() {
+		return fmt.Sprintf("Daemon with PID %v was killed with SIGKILL after %v", r.Pid, elapsed)
+	}
+	return fmt.Sprintf("Daemon with PID %v stopped by %v after %v (step %v of %v)", r.Pid, unix.SignalName(r.Signal),
+		elapsed, r.Step+1, r.Steps)
+}
+
+// SetStopPolicy is an optional method used to set how "<daemon> stop" and "<daemon> restart" escalate when the
+// running daemon does not shut down. If not provided DefaultStopPolicy() is used.
+func (ctx *Context) SetStopPolicy(p StopPolicy) {
+	ctx.stopPolicy = &p
+}
+
+// getStopPolicy returns the stop policy for the daemon, falling back to the default policy.
+func (ctx *Context) getStopPolicy() StopPolicy {
+	if ctx.stopPolicy == nil {
+		return DefaultStopPolicy()
+	}
+	return *ctx.stopPolicy
+}
+
+// stopPolicyFor returns the stop policy for the daemon with the grace period of its first step replaced by d, when d
+// is not zero. Used for StopOptions.Timeout and the --timeout flag of "stop" and "restart".
+func (ctx *Context) stopPolicyFor(d time.Duration) StopPolicy {
+	policy := ctx.getStopPolicy()
+	if d == 0 {
+		return policy
+	}
+	steps := append([]StopStep(nil), policy.Steps...)
+	if len(steps) > 0 {
+		steps[0].Grace = d
+	}
+	policy.Steps = steps
+	return policy
+}
+
+// isRunning returns true if the process still exists; a process that can't be signalled for lack of permission
+// exists. When startTime is known the process must also still have that start time, so a process ID that is reused
+// while waiting is not mistaken for the daemon.
+func isRunning(proc *os.Process, startTime uint64) bool {
+	if err := proc.Signal(syscall.Signal(0)); err != nil && !os.IsPermission(err) {
+		return false
+	}
+	if startTime != 0 {
+		if current, err := processStartTime(proc.Pid); err == nil && current != startTime {
+			return false
+		}
+	}
+	return true
+}
+
+// waitForExit monitors the process for up to grace, printing a progress dot each second, and returns true as soon as
+// the process has gone away.
+func waitForExit(ctx *Context, proc *os.Process, startTime uint64, grace time.Duration) bool {
+	defer ctx.printf("\n")
+
+	deadline := time.Now().UTC().Add(grace)
+	for time.Now().UTC().Before(deadline) {
+		ctx.printf(".")
+		pause := time.Until(deadline)
+		if pause > 1*time.Second {
+			pause = 1 * time.Second
+		}
+		time.Sleep(pause)
+		if !isRunning(proc, startTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// stopDaemon is the function used by the parent process to ask a running daemon process to perform a shutdown.
+// Each step of policy is tried in turn, and if the daemon still hasn't shut down it is sent a SIGKILL (when the
+// policy allows it). The returned StopResult records which step ended the process.
+func stopDaemon(ctx *Context, proc *os.Process, policy StopPolicy) (StopResult, error) {
+	result := StopResult{Pid: proc.Pid, Steps: len(policy.Steps)}
+	start := time.Now().UTC()
+	startTime, _ := processStartTime(proc.Pid)
+
+	for i, step := range policy.Steps {
+		// A SIGTERM as the first step is sent as a stop request over the control socket when it can be reached.
+		if i == 0 && step.Signal == syscall.SIGTERM && requestStop(ctx) {
+			ctx.printf("Sent a stop request to the daemon with PID, %v", proc.Pid)
+		} else {
+			ctx.printf("Sending %v to the daemon with PID, %v", unix.SignalName(step.Signal), proc.Pid)
+			if err := proc.Signal(step.Signal); os.IsPermission(err) {
+				ctx.printf("\n")
+				return result, err
+			}
+		}
+
+		if waitForExit(ctx, proc, startTime, step.Grace) {
+			result.Step = i
+			result.Signal = step.Signal
+			result.Elapsed = time.Since(start)
+			return result, nil
+		}
+	}
+
+	if !policy.AllowKill {
+		return result, ErrStopFailed
+	}
+
+	ctx.printf("Sending SIGKILL to the daemon with PID, %v", proc.Pid)
+	if err := proc.Signal(syscall.SIGKILL); os.IsPermission(err) {
+		ctx.printf("\n")
+		return result, err
+	}
+	if !waitForExit(ctx, proc, startTime, killWaitTime) {
+		return result, ErrStopFailed
+	}
+
+	result.Step = -1
+	result.Signal = syscall.SIGKILL
+	result.Elapsed = time.Since(start)
+	return result, nil
+}
+
+// requestStop asks the running daemon to shut down over its control socket, and returns true if it accepted.
+func requestStop(ctx *Context) bool {
+	resp, err := callControl(ctx, "stop", nil)
+	return err == nil && resp.OK
+}
@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestStopResultExitCode checks the exit code of the "stop" and "restart" commands for each way a daemon can stop.
+func TestStopResultExitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		result StopResult
+		want   ExitCode
+		killed bool
+	}{
+		{"first step", StopResult{Step: 0, Steps: 2, Signal: syscall.SIGTERM}, ExitSuccess, false},
+		{"second step", StopResult{Step: 1, Steps: 2, Signal: syscall.SIGINT}, ExitStopEscalated, false},
+		{"last step", StopResult{Step: 2, Steps: 3, Signal: syscall.SIGQUIT}, ExitStopEscalated, false},
+		{"killed", StopResult{Step: -1, Steps: 2, Signal: syscall.SIGKILL}, ExitStopKilled, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := test.result.ExitCode(); code != test.want {
+				t.Errorf("ExitCode() = %d, want %d", code, test.want)
+			}
+			if killed := test.result.Killed(); killed != test.killed {
+				t.Errorf("Killed() = %v, want %v", killed, test.killed)
+			}
+		})
+	}
+}
+
+// TestStopExitCodeStrings checks that the stop exit codes are described, and not confused with the LSB codes.
+func TestStopExitCodeStrings(t *testing.T) {
+	tests := []struct {
+		code ExitCode
+		want string
+	}{
+		{ExitStopEscalated, "stopped by an escalated signal"},
+		{ExitStopKilled, "stopped by SIGKILL"},
+		{ExitCode(152), "exit status 152"},
+	}
+	for _, test := range tests {
+		if s := test.code.String(); s != test.want {
+			t.Errorf("ExitCode(%d).String() = %q, want %q", int(test.code), s, test.want)
+		}
+	}
+}
+
+// TestStopPolicyFor checks that a timeout replaces the grace period of the first step only, and leaves the policy
+// set on the daemon unchanged.
+func TestStopPolicyFor(t *testing.T) {
+	custom := StopPolicy{
+		Steps: []StopStep{
+			{Signal: syscall.SIGINT, Grace: 2 * time.Second},
+			{Signal: syscall.SIGQUIT, Grace: 3 * time.Second},
+		},
+	}
+	tests := []struct {
+		name    string
+		policy  *StopPolicy
+		timeout time.Duration
+		want    StopPolicy
+	}{
+		{"default", nil, 0, DefaultStopPolicy()},
+		{"default with timeout", nil, time.Minute, StopPolicy{
+			Steps: []StopStep{
+				{Signal: syscall.SIGTERM, Grace: time.Minute},
+				{Signal: syscall.SIGINT, Grace: 10 * time.Second},
+			},
+			AllowKill: true,
+		}},
+		{"custom", &custom, 0, custom},
+		{"custom with timeout", &custom, 5 * time.Second, StopPolicy{
+			Steps: []StopStep{
+				{Signal: syscall.SIGINT, Grace: 5 * time.Second},
+				{Signal: syscall.SIGQUIT, Grace: 3 * time.Second},
+			},
+		}},
+		{"no steps with timeout", &StopPolicy{AllowKill: true}, time.Second, StopPolicy{AllowKill: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := &Context{}
+			if test.policy != nil {
+				ctx.SetStopPolicy(*test.policy)
+			}
+			if policy := ctx.stopPolicyFor(test.timeout); !reflect.DeepEqual(policy, test.want) {
+				t.Errorf("stopPolicyFor(%v) = %+v, want %+v", test.timeout, policy, test.want)
+			}
+		})
+	}
+
+	ctx := &Context{}
+	ctx.SetStopPolicy(custom)
+	_ = ctx.stopPolicyFor(time.Minute)
+	if grace := ctx.getStopPolicy().Steps[0].Grace; grace != 2*time.Second {
+		t.Errorf("stopPolicyFor changed the policy of the daemon, first grace period %v, want %v", grace, 2*time.Second)
+	}
+}
@@ -0,0 +1,152 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// maxCrashRecords is the number of most recent crashes kept for "<daemon> status".
+const maxCrashRecords = 10
+
+// ErrCrashLoop is returned by a supervised daemon that gave up restarting its worker.
+var ErrCrashLoop = errors.New("worker crash loop detected")
+
+// SupervisorPolicy describes how a supervised daemon restarts its worker after a crash. The delay before each restart
+// starts at InitialBackoff and doubles for every crash in the current Window, up to MaxBackoff. Once MaxCrashes
+// crashes have happened within Window the supervisor gives up and the daemon exits with ErrCrashLoop. A zero or
+// negative field takes its value from DefaultSupervisorPolicy, so SupervisorPolicy{} is the default policy and
+// MaxCrashes: 0 doesn't give up on the first crash.
+type SupervisorPolicy struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	MaxCrashes     int
+	Window         time.Duration
+}
+
+// DefaultSupervisorPolicy returns a policy that restarts the worker after 1s, backing off to at most 1 minute, and
+// gives up after 5 crashes within 10 minutes.
+func DefaultSupervisorPolicy() SupervisorPolicy {
+	return SupervisorPolicy{
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     1 * time.Minute,
+		MaxCrashes:     5,
+		Window:         10 * time.Minute,
+	}
+}
+
+// Crash describes a single worker crash seen by the supervisor.
+type Crash struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	Stack  string    `json:"stack,omitempty"`
+}
+
+// SetSupervisor is an optional method used to turn on supervision of the worker. A supervised worker that panics,
+// or a run function that returns an error before the daemon was asked to stop, is restarted according to p instead
+// of taking the daemon down. Each crash is logged with its stack trace and reported by "<daemon> status". A worker
+// set with SetWorkerHandler is supervised as if it was a run function that ignores its context.
+func (ctx *Context) SetSupervisor(p SupervisorPolicy) {
+	defaults := DefaultSupervisorPolicy()
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaults.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaults.MaxBackoff
+	}
+	if p.MaxCrashes <= 0 {
+		p.MaxCrashes = defaults.MaxCrashes
+	}
+	if p.Window <= 0 {
+		p.Window = defaults.Window
+	}
+	ctx.supervisor = &p
+}
+
+// backoff returns the delay before restarting the worker after the given number of crashes within the window.
+func (p SupervisorPolicy) backoff(crashes int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < crashes && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// recentCrashes returns the times of the crashes in recent within the window before now, followed by now, the time
+// of the latest crash. Only these count towards the limit and the backoff. recent is reused.
+func (p SupervisorPolicy) recentCrashes(recent []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-p.Window)
+	kept := recent[:0]
+	for _, t := range recent {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	return append(kept, now)
+}
+
+// supervise is the function used by the daemon process to run the protected worker f under the daemon's
+// SupervisorPolicy, or the default policy when only the PanicPolicy asks for restarts. It returns when f returns
+// without an error, when runCtx is cancelled, when the crash limit has been reached, or when the crash is one that
+// shouldn't be restarted: a panic under PanicExit, or an error returned by an unsupervised worker.
+func (ctx *Context) supervise(runCtx context.Context, f RunFunc) error {
+	policy := DefaultSupervisorPolicy()
+	if ctx.supervisor != nil {
+		policy = *ctx.supervisor
+	}
+	var recent []time.Time
+
+	for {
+		err := f(runCtx)
+		if err == nil || runCtx.Err() != nil {
+			return err
+		}
+		_, isPanic := err.(*PanicError)
+		if (isPanic && ctx.getPanicPolicy() == PanicExit) || (!isPanic && ctx.supervisor == nil) {
+			return err
+		}
+
+		// The stack trace of a panic has already been logged by protect().
+		crash := ctx.recordCrash(err)
+		log.Printf("Daemon %v worker crashed, %v\n", ctx.goctx.Args[0], crash.Reason)
+
+		recent = policy.recentCrashes(recent, crash.Time)
+		if len(recent) >= policy.MaxCrashes {
+			log.Printf("Daemon %v worker crashed %v times within %v, giving up\n", ctx.goctx.Args[0], len(recent),
+				policy.Window)
+			return ErrCrashLoop
+		}
+
+		delay := policy.backoff(len(recent))
+		log.Printf("Daemon %v restarting worker in %v\n", ctx.goctx.Args[0], delay)
+		select {
+		case <-runCtx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// recordCrash saves a crash in the daemon's run-time state for the status command, and returns it.
+func (ctx *Context) recordCrash(err error) Crash {
+	crash := Crash{Time: time.Now().UTC(), Reason: err.Error()}
+	if panicErr, ok := err.(*PanicError); ok {
+		crash.Stack = string(panicErr.Stack)
+	}
+	if ctx.state == nil {
+		return crash
+	}
+
+	ctx.state.mu.Lock()
+	defer ctx.state.mu.Unlock()
+	ctx.state.crashCount++
+	ctx.state.crashes = append(ctx.state.crashes, crash)
+	if len(ctx.state.crashes) > maxCrashRecords {
+		ctx.state.crashes = ctx.state.crashes[len(ctx.state.crashes)-maxCrashRecords:]
+	}
+	return crash
+}
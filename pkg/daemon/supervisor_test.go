@@ -0,0 +1,178 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSupervisorBackoff checks that the restart delay doubles for every crash in the window, up to MaxBackoff.
+func TestSupervisorBackoff(t *testing.T) {
+	policy := SupervisorPolicy{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	tests := []struct {
+		crashes int
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, test := range tests {
+		if delay := policy.backoff(test.crashes); delay != test.want {
+			t.Errorf("backoff(%v) = %v, want %v", test.crashes, delay, test.want)
+		}
+	}
+
+	policy = SupervisorPolicy{InitialBackoff: time.Minute, MaxBackoff: time.Second}
+	if delay := policy.backoff(1); delay != time.Second {
+		t.Errorf("backoff(1) with InitialBackoff above MaxBackoff = %v, want %v", delay, time.Second)
+	}
+}
+
+// TestSetSupervisorDefaults checks that the zero and negative fields of a SupervisorPolicy take their values from the
+// default policy.
+func TestSetSupervisorDefaults(t *testing.T) {
+	defaults := DefaultSupervisorPolicy()
+	tests := []struct {
+		name   string
+		policy SupervisorPolicy
+		want   SupervisorPolicy
+	}{
+		{"zero", SupervisorPolicy{}, defaults},
+		{"max crashes", SupervisorPolicy{MaxCrashes: 2}, SupervisorPolicy{InitialBackoff: defaults.InitialBackoff,
+			MaxBackoff: defaults.MaxBackoff, MaxCrashes: 2, Window: defaults.Window}},
+		{"all set", SupervisorPolicy{time.Millisecond, time.Second, 3, time.Minute},
+			SupervisorPolicy{time.Millisecond, time.Second, 3, time.Minute}},
+		{"negative", SupervisorPolicy{-time.Second, -time.Minute, -1, -time.Hour}, defaults},
+		{"negative backoff", SupervisorPolicy{InitialBackoff: -time.Millisecond, MaxBackoff: time.Second},
+			SupervisorPolicy{InitialBackoff: defaults.InitialBackoff, MaxBackoff: time.Second,
+				MaxCrashes: defaults.MaxCrashes, Window: defaults.Window}},
+	}
+	for _, test := range tests {
+		ctx := &Context{}
+		ctx.SetSupervisor(test.policy)
+		if !reflect.DeepEqual(*ctx.supervisor, test.want) {
+			t.Errorf("%v: SetSupervisor(%+v) set %+v, want %+v", test.name, test.policy, *ctx.supervisor, test.want)
+		}
+	}
+}
+
+// TestRecentCrashes checks that the crashes before the window are pruned, and the latest crash added.
+func TestRecentCrashes(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) time.Time {
+		return now.Add(-d)
+	}
+	policy := SupervisorPolicy{Window: 10 * time.Minute}
+	tests := []struct {
+		name   string
+		recent []time.Time
+		want   []time.Time
+	}{
+		{"first crash", nil, []time.Time{now}},
+		{"within the window", []time.Time{ago(9 * time.Minute), ago(time.Minute)},
+			[]time.Time{ago(9 * time.Minute), ago(time.Minute), now}},
+		{"before the window", []time.Time{ago(time.Hour), ago(11 * time.Minute), ago(time.Minute)},
+			[]time.Time{ago(time.Minute), now}},
+		{"at the start of the window", []time.Time{ago(10 * time.Minute)}, []time.Time{now}},
+		{"all before the window", []time.Time{ago(time.Hour), ago(30 * time.Minute)}, []time.Time{now}},
+	}
+	for _, test := range tests {
+		if recent := policy.recentCrashes(test.recent, now); !reflect.DeepEqual(recent, test.want) {
+			t.Errorf("%v: recentCrashes() = %v, want %v", test.name, recent, test.want)
+		}
+	}
+}
+
+// TestSupervise checks when the supervisor restarts the worker, and when it returns.
+func TestSupervise(t *testing.T) {
+	errFailed := errors.New("failed")
+	fast := SupervisorPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, MaxCrashes: 3,
+		Window: time.Minute}
+	// Crashes further apart than the window never add up to the limit.
+	slow := SupervisorPolicy{InitialBackoff: 20 * time.Millisecond, MaxBackoff: 20 * time.Millisecond,
+		MaxCrashes: 2, Window: 10 * time.Millisecond}
+
+	tests := []struct {
+		name   string
+		policy *SupervisorPolicy
+		panics PanicPolicy
+		fails  int
+		err    error
+		runs   int
+	}{
+		{"no crash", &fast, 0, 0, nil, 1},
+		{"restarted", &fast, 0, 2, nil, 3},
+		{"crash loop", &fast, 0, 10, ErrCrashLoop, 3},
+		{"crashes outside the window", &slow, 0, 4, nil, 5},
+		{"unsupervised error", nil, 0, 1, errFailed, 1},
+		{"panic under PanicExit", &fast, PanicExit, 1, nil, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := newTestDaemon(t.TempDir(), "")
+			if test.policy != nil {
+				ctx.SetSupervisor(*test.policy)
+			}
+			ctx.SetPanicPolicy(test.panics)
+
+			runs := 0
+			err := ctx.supervise(context.Background(), func(context.Context) error {
+				runs++
+				switch {
+				case runs > test.fails:
+					return nil
+				case test.panics != 0:
+					return &PanicError{Value: "boom"}
+				default:
+					return errFailed
+				}
+			})
+			if test.panics == PanicExit {
+				if _, ok := err.(*PanicError); !ok {
+					t.Errorf("supervise() = %v, want the panic", err)
+				}
+			} else if err != test.err {
+				t.Errorf("supervise() = %v, want %v", err, test.err)
+			}
+			if runs != test.runs {
+				t.Errorf("the worker ran %v times, want %v", runs, test.runs)
+			}
+		})
+	}
+}
+
+// TestSuperviseCancelled checks that a supervised worker is not restarted once the daemon has been asked to stop.
+func TestSuperviseCancelled(t *testing.T) {
+	ctx := newTestDaemon(t.TempDir(), "")
+	ctx.SetSupervisor(SupervisorPolicy{InitialBackoff: time.Hour})
+	runCtx, cancel := context.WithCancel(context.Background())
+
+	runs := 0
+	done := make(chan error)
+	go func() {
+		done <- ctx.supervise(runCtx, func(context.Context) error {
+			runs++
+			return errors.New("failed")
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("supervise() = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("supervise() didn't return after the context was cancelled")
+	}
+	if runs != 1 {
+		t.Errorf("the worker ran %v times, want 1", runs)
+	}
+}
@@ -0,0 +1,151 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	godaemon "github.com/sevlyar/go-daemon"
+)
+
+// DefaultHeartbeatTimeout is how long the heartbeat may stand still before the systemd watchdog pings stop, when
+// SetHeartbeatTimeout has not been called.
+const DefaultHeartbeatTimeout = 5 * time.Minute
+
+// notifier sends sd_notify(3) state messages to the service manager over the socket named by $NOTIFY_SOCKET. A nil
+// notifier is valid, and silently drops every message.
+type notifier struct {
+	addr *net.UnixAddr
+}
+
+// newNotifier returns a notifier for $NOTIFY_SOCKET, or nil when the daemon isn't running under a service manager
+// that supports notifications.
+func newNotifier() *notifier {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	// A leading '@' names a socket in the Linux abstract namespace.
+	if strings.HasPrefix(name, "@") {
+		name = "\x00" + name[1:]
+	}
+	return &notifier{addr: &net.UnixAddr{Name: name, Net: "unixgram"}}
+}
+
+// notify sends the newline separated state assignments, e.g. "READY=1", to the service manager.
+func (n *notifier) notify(state ...string) error {
+	if n == nil {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, n.addr)
+	if err != nil {
+		return err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(strings.Join(state, "\n")))
+	return err
+}
+
+// watchdogInterval returns the interval at which the service manager expects WATCHDOG=1 pings, or 0 if the watchdog
+// is not enabled for this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// SetHeartbeatTimeout is an optional method used to set how long the heartbeat reported with RecordHeartbeat may
+// stand still before the daemon stops sending watchdog pings to systemd, so that systemd restarts it. If not
+// provided DefaultHeartbeatTimeout is used.
+func (ctx *Context) SetHeartbeatTimeout(d time.Duration) {
+	ctx.heartbeatTimeout = d
+}
+
+// Ready is called by a run function once it has finished initializing. Under systemd with Type=notify this sends
+// READY=1, which is what makes "systemctl start" return. The ctx parameter is the context passed to the run function.
+// "<daemon> start" waits for Ready, so a run function that never calls it fails to start once the start timeout has
+// passed. A worker set with SetWorkerHandler is reported ready as soon as it has been started.
+func Ready(ctx context.Context) {
+	state, ok := ctx.Value(stateKey{}).(*runState)
+	if !ok {
+		return
+	}
+	state.ready()
+}
+
+// ready records that the worker has initialized, and tells the service manager.
+func (state *runState) ready() {
+	state.mu.Lock()
+	state.isReady = true
+	n := state.notifier
+	state.startup.report(nil)
+	state.mu.Unlock()
+
+	_ = n.notify("READY=1", fmt.Sprintf("MAINPID=%d", os.Getpid()))
+	state.reportUpgradeReady()
+}
+
+// watchdog sends WATCHDOG=1 to the service manager at half the given interval for as long as the heartbeat keeps
+// advancing, and returns when runCtx is cancelled.
+func (state *runState) watchdog(runCtx context.Context, interval time.Duration, timeout time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-runCtx.Done():
+			return
+		case <-ticker.C:
+			state.mu.Lock()
+			advanced := state.heartbeatAdvanced
+			n := state.notifier
+			state.mu.Unlock()
+
+			if time.Since(advanced) < timeout {
+				_ = n.notify("WATCHDOG=1")
+			}
+		}
+	}
+}
+
+// runForeground is the function used to run the daemon as a foreground process under a service manager such as
+// systemd, which does its own process tracking and must not see go-daemon's re-invocation. The PID file, working
+// directory and umask are set up as they would be for the daemon, and then the daemon runs exactly as after a
+// "start", with service manager notifications sent over $NOTIFY_SOCKET.
+func runForeground(ctx *Context) error {
+	ctx.foreground = true
+	if err := ctx.makeDirs(); err != nil {
+		return err
+	}
+	if ctx.goctx.PidFileName != "" {
+		lock, err := godaemon.CreatePidFile(ctx.goctx.PidFileName, ctx.goctx.PidFilePerm)
+		if err != nil {
+			return err
+		}
+		//noinspection GoUnhandledErrorResult
+		defer lock.Remove()
+	}
+	if ctx.goctx.WorkDir != "" {
+		if err := os.Chdir(ctx.goctx.WorkDir); err != nil {
+			return err
+		}
+	}
+	if ctx.goctx.Umask != 0 {
+		syscall.Umask(ctx.goctx.Umask)
+	}
+
+	return runDaemon(ctx)
+}
@@ -0,0 +1,74 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestNotifier checks that the state changes of a daemon reach the socket named by $NOTIFY_SOCKET: READY=1 once the
+// worker is ready, RELOADING=1 then READY=1 around a reload, and STOPPING=1 when it is terminated.
+func TestNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//noinspection GoUnhandledErrorResult
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	ctx := &Context{}
+	if err = ctx.New("", "", "/", "test"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.SetRunHandler(func(runCtx context.Context) error { return nil })
+	ctx.SetReloadHandler(func(_ os.Signal) error { return nil })
+	newRunState(ctx, context.Background())
+	ctx.state.notifier = newNotifier()
+
+	ctx.state.ready()
+	if _, err = ctx.reload(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	close(done)
+	if err = ctx.terminate(syscall.SIGTERM, func() {}, done); err == nil {
+		t.Fatal("terminate kept the daemon running")
+	}
+
+	want := []string{fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid()), "RELOADING=1", "READY=1", "STOPPING=1"}
+	buf := make([]byte, 1024)
+	for _, msg := range want {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("no %q notification, %v", strings.SplitN(msg, "\n", 2)[0], err)
+		}
+		if got := string(buf[:n]); got != msg {
+			t.Errorf("notification %q, want %q", got, msg)
+		}
+	}
+}
+
+// TestNotifierAbstractSocket checks that a $NOTIFY_SOCKET starting with '@' names a socket in the abstract namespace.
+func TestNotifierAbstractSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "@go-daemons/notify")
+	if n := newNotifier(); n == nil || n.addr.Name != "\x00go-daemons/notify" {
+		t.Errorf("newNotifier() = %+v, want the abstract socket go-daemons/notify", n)
+	}
+
+	t.Setenv("NOTIFY_SOCKET", "")
+	if n := newNotifier(); n != nil {
+		t.Errorf("newNotifier() = %+v without NOTIFY_SOCKET, want nil", n)
+	}
+	if err := (*notifier)(nil).notify("READY=1"); err != nil {
+		t.Errorf("a nil notifier failed, %v", err)
+	}
+}